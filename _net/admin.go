@@ -0,0 +1,240 @@
+package _net
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (_this *server) RegAdminListen(host string, port int, token string) {
+	if token == "" {
+		logs.Panic("admin token empty")
+	}
+
+	_this.adminAddr = fmt.Sprintf("%s:%d", host, port)
+	_this.adminToken = token
+}
+
+// 管理接口的处理函数, 路由说明见 IServer.RegAdminListen, 所有路由都需要 token
+func (_this *server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/conns", _this.adminConns)
+	mux.HandleFunc("/admin/kick", _this.adminKick)
+	mux.HandleFunc("/admin/broadcast", _this.adminBroadcast)
+	mux.HandleFunc("/admin/debug", _this.adminDebug)
+	mux.HandleFunc("/admin/workers", _this.adminWorkers)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !_this.checkAdminToken(r) {
+			writeJson(w, http.StatusUnauthorized, adminError("invalid token"))
+			return
+		}
+
+		// 只记录路径, url 参数中可能带有 token
+		logs.System("admin request:", r.Method, r.URL.Path, "remote addr:", r.RemoteAddr)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// 校验 token, 支持 Authorization: Bearer <token> 和 ?token=<token>
+func (_this *server) checkAdminToken(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(_this.adminToken)) == 1
+}
+
+// 连接信息
+type adminConn struct {
+	Socket string                 `json:"socket"`
+	ID     int                    `json:"id"`
+	Addr   string                 `json:"addr"`
+	Attrs  map[string]interface{} `json:"attrs"`
+}
+
+func (_this *server) adminConns(w http.ResponseWriter, r *http.Request) {
+	sockets, ok := _this.adminSockets(w, r.URL.Query().Get("socket"))
+	if !ok {
+		return
+	}
+
+	conns := make([]adminConn, 0)
+	for _, s := range sockets {
+		s.Range(func(conn IConn) bool {
+			conns = append(conns, adminConn{
+				Socket: s.name,
+				ID:     conn.GetConnID(),
+				Addr:   fmt.Sprint(conn.GetRemoteAddr()),
+				Attrs:  jsonAttrs(conn),
+			})
+			return true
+		})
+	}
+
+	writeJson(w, http.StatusOK, conns)
+}
+
+func (_this *server) adminKick(w http.ResponseWriter, r *http.Request) {
+	if !adminMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	sockets, ok := _this.adminSockets(w, r.URL.Query().Get("socket"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, adminError("invalid conn id"))
+		return
+	}
+
+	// 连接ID只在 socket 内唯一, 多个 socket 中都有该连接时需要指定 socket
+	var found []*socket
+	for _, s := range sockets {
+		if _, ok := s.GetConn(id); ok {
+			found = append(found, s)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		writeJson(w, http.StatusNotFound, adminError("conn not found"))
+	case 1:
+		found[0].Kick(id)
+		logs.System("admin kick conn:", id, "socket:", found[0].name)
+		writeJson(w, http.StatusOK, map[string]interface{}{"socket": found[0].name, "id": id})
+	default:
+		writeJson(w, http.StatusConflict, adminError("conn id exists in multiple sockets, need socket"))
+	}
+}
+
+// 广播消息的请求, data 作为原始字节发送
+type adminBroadcastReq struct {
+	Socket string `json:"socket"`
+	MsgID  int    `json:"msg_id"`
+	Data   string `json:"data"`
+}
+
+func (_this *server) adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !adminMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req adminBroadcastReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJson(w, http.StatusBadRequest, adminError("invalid body: "+err.Error()))
+		return
+	}
+
+	sockets, ok := _this.adminSockets(w, req.Socket)
+	if !ok {
+		return
+	}
+
+	count := 0
+	for _, s := range sockets {
+		count += s.Count()
+		s.BroadcastMsg(req.MsgID, []byte(req.Data))
+	}
+
+	logs.System("admin broadcast msg id:", req.MsgID, "conns:", count)
+	writeJson(w, http.StatusOK, map[string]interface{}{"msg_id": req.MsgID, "conns": count})
+}
+
+func (_this *server) adminDebug(w http.ResponseWriter, r *http.Request) {
+	if !adminMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	enable, err := strconv.ParseBool(r.URL.Query().Get("enable"))
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, adminError("invalid enable"))
+		return
+	}
+
+	logs.SetDebug(enable)
+	logs.System("admin set debug:", enable)
+	writeJson(w, http.StatusOK, map[string]interface{}{"debug": logs.IsDebug()})
+}
+
+// worker 池状态
+type adminWorkers struct {
+	Socket  string       `json:"socket"`
+	Workers []WorkerStat `json:"workers"`
+}
+
+func (_this *server) adminWorkers(w http.ResponseWriter, r *http.Request) {
+	sockets, ok := _this.adminSockets(w, r.URL.Query().Get("socket"))
+	if !ok {
+		return
+	}
+
+	workers := make([]adminWorkers, 0, len(sockets))
+	for _, s := range sockets {
+		workers = append(workers, adminWorkers{Socket: s.name, Workers: s.WorkerStats()})
+	}
+
+	writeJson(w, http.StatusOK, workers)
+}
+
+// 按名称查找 socket, name 为空时返回所有 socket, 未找到时输出错误
+func (_this *server) adminSockets(w http.ResponseWriter, name string) ([]*socket, bool) {
+	_this.mu.Lock()
+	sockets := _this.sockets
+	_this.mu.Unlock()
+
+	if name == "" {
+		return sockets, true
+	}
+
+	for _, s := range sockets {
+		if s.name == name {
+			return []*socket{s}, true
+		}
+	}
+
+	writeJson(w, http.StatusNotFound, adminError("socket not found: "+name))
+	return nil, false
+}
+
+// 检查请求方法
+func adminMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeJson(w, http.StatusMethodNotAllowed, adminError("method not allowed, need: "+method))
+		return false
+	}
+
+	return true
+}
+
+// 连接属性, 不能编码为 json 的值转换为字符串
+func jsonAttrs(conn IConn) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	conn.QueryAttr().Range(func(key, value interface{}) bool {
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprint(value)
+		}
+		attrs[fmt.Sprint(key)] = value
+		return true
+	})
+
+	return attrs
+}
+
+func adminError(msg string) map[string]string {
+	return map[string]string{"error": msg}
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
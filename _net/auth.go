@@ -0,0 +1,100 @@
+package _net
+
+import (
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func (_this *socket) SetUpgradeAuth(auth func(request *http.Request, attr *sync.Map) int) {
+	_this.upgradeAuth = auth
+}
+
+func (_this *socket) SetFrameAuth(timeout time.Duration, auth func(request Request) error) {
+	if auth == nil {
+		logs.Panic("frame auth nil")
+	}
+
+	_this.frameAuth = auth
+	_this.frameAuthTimeout = timeout
+}
+
+// 可以认证的连接
+type authConn interface {
+	sessionConn
+	// 读取一帧, 返回消息头和解密后的消息体
+	readFrame() (PacketHead, []byte, error)
+}
+
+// 建立连接后依次握手, 恢复会话, 认证第一条消息, 失败时取消恢复的会话
+func (_this *socket) accept(conn authConn) error {
+	err := _this.handshake(conn)
+	if err == nil {
+		err = _this.resume(conn)
+	}
+	if err == nil {
+		if err = _this.authFrame(conn); err != nil {
+			_this.abortSession(conn)
+		}
+	}
+
+	return err
+}
+
+// websocket 升级前认证, 返回是否允许升级, 拒绝时输出 auth 返回的状态码
+func (_this *socket) authUpgrade(writer http.ResponseWriter, request *http.Request, attr *sync.Map) bool {
+	if _this.upgradeAuth == nil {
+		return true
+	}
+
+	status := _this.upgradeAuth(request, attr)
+	if status == http.StatusOK {
+		return true
+	}
+
+	// 无效的状态码按 403 处理
+	if status < 100 || status > 999 {
+		status = http.StatusForbidden
+	}
+
+	logs.Debug("websocket upgrade auth reject, status:", status, "remote addr:", request.RemoteAddr)
+	http.Error(writer, http.StatusText(status), status)
+	return false
+}
+
+// 认证第一条消息, 消息交给 auth 处理, 不进入 worker 池
+func (_this *socket) authFrame(conn authConn) error {
+	if _this.frameAuth == nil || _this.client {
+		return nil
+	}
+
+	if _this.frameAuthTimeout > 0 {
+		conn.setHandshakeDeadline(time.Now().Add(_this.frameAuthTimeout))
+		defer conn.setHandshakeDeadline(time.Time{})
+	}
+
+	head, body, err := conn.readFrame()
+	if err != nil {
+		return fmt.Errorf("auth err: %v", err)
+	}
+
+	if head.Flags&FlagCompressed != 0 {
+		if body, err = _this.decompressBody(body); err != nil {
+			return fmt.Errorf("auth err: decompress err: %v, msg id: %d", err, head.ID)
+		}
+	}
+
+	data, err := _this.unmarshal(head.ID, body)
+	if err != nil {
+		return fmt.Errorf("auth err: unmarshal err: %v, msg id: %d", err, head.ID)
+	}
+
+	request := Request{Conn: conn, ID: head.ID, Seq: head.Seq, Key: conn.GetConnID(), Data: data}
+	if err = _this.frameAuth(request); err != nil {
+		return fmt.Errorf("auth err: %v, msg id: %d", err, head.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package _net
+
+import "time"
+
+// 按照 socket 的背压策略把消息放入连接的发送队列
+// exit, writeDone 关闭后直接丢弃消息
+func (_this *socket) enqueue(conn IConn, queue chan []byte, exit <-chan bool, writeDone <-chan bool, msg []byte) {
+	// 连接已断开, 交给保留中的会话
+	select {
+	case <-exit:
+		_this.forwardSession(conn, msg)
+		return
+	default:
+	}
+
+	// 队列未满时直接放入
+	select {
+	case <-exit:
+		return
+	case <-writeDone:
+		return
+	case queue <- msg:
+		return
+	default:
+	}
+
+	switch _this.backpressure {
+	case BackpressureDropNewest:
+		// 丢弃当前消息
+	case BackpressureDropOldest:
+		// 丢弃队列中最早的消息, 直到当前消息放入队列
+		for {
+			select {
+			case <-exit:
+				return
+			case <-writeDone:
+				return
+			case <-queue:
+			default:
+			}
+
+			select {
+			case queue <- msg:
+				_this.onBackpressure(conn)
+				return
+			default:
+			}
+		}
+	case BackpressureDisconnect:
+		_this.onBackpressure(conn)
+		stopConn(conn, ErrSlowConsumer)
+		return
+	default:
+		var timeout <-chan time.Time
+		if _this.backpressureTimeout > 0 {
+			timer := time.NewTimer(_this.backpressureTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-exit:
+			return
+		case <-writeDone:
+			return
+		case queue <- msg:
+			return
+		case <-timeout:
+			// 超时丢弃当前消息
+		}
+	}
+
+	_this.onBackpressure(conn)
+}
+
+// 背压策略触发
+func (_this *socket) onBackpressure(conn IConn) {
+	if _this.backpressureCall != nil {
+		_this.backpressureCall(conn, _this.backpressure)
+	}
+}
@@ -0,0 +1,166 @@
+package _net
+
+import (
+	"github.com/fly-way/gofly/logs"
+	"sync"
+	"time"
+)
+
+// 序列号最高位为 1 表示应答, 请求的序列号只使用低 31 位
+const replySeqFlag uint32 = 1 << 31
+
+// 请求序列号对应的应答序列号, 0 表示请求不带序列号, 应答也不带
+func replySeq(seq uint32) uint32 {
+	if seq == 0 {
+		return 0
+	}
+
+	return seq | replySeqFlag
+}
+
+// 应答请求, 应答的消息ID与请求相同, 对端 Call 收到后返回 data
+// 请求不带序列号时(对端使用 SendMsg 发送), 等同于 SendMsg
+func (_this Request) Reply(data interface{}) {
+	if err := sendHead(_this.Conn, PacketHead{ID: _this.ID, Seq: replySeq(_this.Seq)}, data); err != nil {
+		logs.Error("reply err:", err, "msg id:", _this.ID)
+	}
+}
+
+// 等待应答的请求
+type pendingCall struct {
+	result chan CallResult
+	timer  *time.Timer
+}
+
+// 连接上等待应答的请求集合
+type callManager struct {
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]*pendingCall
+	// 连接断开后不再接受新的请求
+	reason error
+}
+
+func newCallManager() *callManager {
+	return &callManager{pending: make(map[uint32]*pendingCall)}
+}
+
+// 分配序列号, 连接已断开时返回断开原因
+func (_this *callManager) add(result chan CallResult) (uint32, error) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.reason != nil {
+		return 0, _this.reason
+	}
+
+	if _this.seq++; _this.seq >= replySeqFlag {
+		_this.seq = 1
+	}
+	_this.pending[_this.seq] = &pendingCall{result: result}
+
+	return _this.seq, nil
+}
+
+// 设置请求超时, 超时后返回 ErrCallTimeout
+func (_this *callManager) timeout(seq uint32, timeout time.Duration) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if call, ok := _this.pending[seq]; ok {
+		call.timer = time.AfterFunc(timeout, func() {
+			_this.done(seq, CallResult{Err: ErrCallTimeout})
+		})
+	}
+}
+
+// 请求完成, 请求已超时或已完成时返回 false
+func (_this *callManager) done(seq uint32, result CallResult) bool {
+	_this.mu.Lock()
+	call, ok := _this.pending[seq]
+	delete(_this.pending, seq)
+	_this.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	call.result <- result
+	return true
+}
+
+// 连接断开, 所有等待中的请求返回断开原因
+func (_this *callManager) close(reason error) {
+	_this.mu.Lock()
+	pending := _this.pending
+	_this.pending = make(map[uint32]*pendingCall)
+	_this.reason = reason
+	_this.mu.Unlock()
+
+	for _, call := range pending {
+		if call.timer != nil {
+			call.timer.Stop()
+		}
+		call.result <- CallResult{Err: reason}
+	}
+}
+
+// 发送请求, 返回接收应答的通道, 通道中只会有一个结果
+func (_this *socket) call(conn IConn, calls *callManager, id int, data interface{}, timeout time.Duration) <-chan CallResult {
+	result := make(chan CallResult, 1)
+
+	if !_this.seq {
+		result <- CallResult{Err: ErrSeqDisabled}
+		return result
+	}
+
+	seq, err := calls.add(result)
+	if err != nil {
+		result <- CallResult{Err: err}
+		return result
+	}
+
+	if timeout > 0 {
+		calls.timeout(seq, timeout)
+	}
+
+	if err = sendHead(conn, PacketHead{ID: uint32(id), Seq: seq}, data); err != nil {
+		calls.done(seq, CallResult{Err: err})
+	}
+
+	return result
+}
+
+// 处理收到的应答
+func (_this *socket) handleReply(conn IConn, head PacketHead, body []byte) error {
+	data, err := _this.unmarshalReply(head.ID, body)
+	if err != nil {
+		return err
+	}
+
+	if !connCalls(conn).done(head.Seq&^replySeqFlag, CallResult{Data: data}) {
+		logs.Debug("reply ignored, call timeout or unknown, msg id:", head.ID, "seq:", head.Seq&^replySeqFlag)
+	}
+
+	return nil
+}
+
+// 按消息头发送消息
+func sendHead(conn IConn, head PacketHead, data interface{}) error {
+	if c, ok := conn.(interface {
+		sendHead(PacketHead, interface{}) error
+	}); ok {
+		return c.sendHead(head, data)
+	}
+
+	conn.SendMsg(int(head.ID), data)
+	return nil
+}
+
+// 连接上等待应答的请求集合
+func connCalls(conn IConn) *callManager {
+	return conn.(interface{ getCalls() *callManager }).getCalls()
+}
@@ -0,0 +1,347 @@
+package _net
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 创建客户端配置, network 可选 "tcp", "websocket", "kcp"
+// 设置方式与服务端的 ISocket 一致, 如 AesEncrypt, SetByteOrder, SetPacketMaxSize, SetCodec, SetPacker, Route 等
+// 双端设置需要保持一致, Listen, Shutdown 等服务端方法对客户端无意义
+func NewClientSocket(network string) ISocket {
+	s := newSocket(network)
+	s.client = true
+	return s
+}
+
+// 连接 tcp 服务端, addr 如 "127.0.0.1:9999"
+// s 为 NewClientSocket("tcp") 创建的客户端配置, 为 nil 时使用默认配置
+// s 设置了 tls 时使用 tls 连接
+func DialTcp(addr string, s ISocket) (IClient, error) {
+	serve, err := clientSocket(s, "tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(serve, func(prev IConn) (IConn, error) {
+		var (
+			conn net.Conn
+			err  error
+		)
+
+		if config := serve.getTLSConfig(); config != nil {
+			conn, err = tls.Dial("tcp", addr, config)
+		} else {
+			conn, err = net.Dial("tcp", addr)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return clientConnect(serve, newConnTcp(serve.newConnID(), serve, conn), conn, prev)
+	})
+}
+
+// 连接 websocket 服务端, url 如 "ws://127.0.0.1:9999/ws", "wss://127.0.0.1:9999/ws"
+// s 为 NewClientSocket("websocket") 创建的客户端配置, 为 nil 时使用默认配置
+func DialWebsocket(url string, s ISocket) (IClient, error) {
+	serve, err := clientSocket(s, "websocket")
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		TLSClientConfig:  serve.getTLSConfig(),
+	}
+
+	return newClient(serve, func(prev IConn) (IConn, error) {
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return clientConnect(serve, newConnWebsocket(serve.newConnID(), serve, conn), conn, prev)
+	})
+}
+
+// 连接 kcp 服务端, addr 如 "127.0.0.1:9999"
+// s 为 NewClientSocket("kcp") 创建的客户端配置, 为 nil 时使用默认配置
+// udp 无连接, 未开启握手时服务端不可达也会连接成功, 之后发送的消息超过重传次数时断开
+func DialKcp(addr string, s ISocket) (IClient, error) {
+	serve, err := clientSocket(s, "kcp")
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(serve, func(prev IConn) (IConn, error) {
+		sess, err := dialKcp("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return clientConnect(serve, newConnKcp(serve.newConnID(), serve, sess), sess, prev)
+	})
+}
+
+// 客户端握手和恢复会话, 失败时关闭底层连接, 说明见 ISocket.SetHandshakePin, ISocket.SetResume
+// prev 为断开的上一条连接, 首次连接时为 nil
+func clientConnect(serve *socket, conn IConn, raw io.Closer, prev IConn) (IConn, error) {
+	err := serve.handshake(conn.(handshakeConn))
+	if err == nil {
+		err = serve.clientResume(conn.(sessionConn), prev)
+	}
+
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// 默认客户端配置, 每种 network 共用一个
+var (
+	defaultClientMu      sync.Mutex
+	defaultClientSockets = make(map[string]*socket)
+)
+
+// 检查客户端配置, s 为 nil 时使用共用的默认配置
+func clientSocket(s ISocket, network string) (*socket, error) {
+	if s == nil {
+		defaultClientMu.Lock()
+		defer defaultClientMu.Unlock()
+
+		serve, ok := defaultClientSockets[network]
+		if !ok {
+			serve = NewClientSocket(network).(*socket)
+			defaultClientSockets[network] = serve
+		}
+		return serve, nil
+	}
+
+	serve, ok := s.(*socket)
+	if !ok {
+		return nil, errors.New("client socket unknown type")
+	}
+
+	if serve.network != network {
+		return nil, fmt.Errorf("client socket network mismatch: %s, need: %s", serve.network, network)
+	}
+
+	return serve, nil
+}
+
+type client struct {
+	serve    *socket
+	dial     func(prev IConn) (IConn, error)
+	mu       sync.RWMutex
+	conn     IConn
+	minDelay time.Duration
+	maxDelay time.Duration
+	exit     chan bool
+	once     sync.Once
+	// 不再重连时释放客户端配置
+	releaseOnce sync.Once
+}
+
+func newClient(serve *socket, dial func(prev IConn) (IConn, error)) (*client, error) {
+	serve.retainClient()
+
+	conn, err := dial(nil)
+	if err != nil {
+		serve.releaseClient()
+		return nil, err
+	}
+
+	c := &client{
+		serve: serve,
+		dial:  dial,
+		conn:  conn,
+		exit:  make(chan bool),
+	}
+	c.Start()
+
+	return c, nil
+}
+
+func (_this *client) SetReconnect(minDelay time.Duration, maxDelay time.Duration) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.minDelay = minDelay
+	_this.maxDelay = maxDelay
+}
+
+func (_this *client) IsConnected() bool {
+	select {
+	case <-connDone(_this.getConn()):
+		return false
+	default:
+		return true
+	}
+}
+
+func (_this *client) Start() {
+	_this.getConn().Start()
+	go _this.keepAlive()
+}
+
+func (_this *client) Stop() {
+	_this.once.Do(func() {
+		_this.mu.Lock()
+		close(_this.exit)
+		conn := _this.conn
+		_this.mu.Unlock()
+
+		conn.Stop()
+		_this.release()
+	})
+}
+
+// 释放客户端配置, 只会执行一次
+func (_this *client) release() {
+	_this.releaseOnce.Do(_this.serve.releaseClient)
+}
+
+func (_this *client) GetConnID() int {
+	return _this.getConn().GetConnID()
+}
+
+func (_this *client) GetRemoteAddr() net.Addr {
+	return _this.getConn().GetRemoteAddr()
+}
+
+func (_this *client) GetStopReason() error {
+	return _this.getConn().GetStopReason()
+}
+
+func (_this *client) WriteMsg(msg []byte) {
+	_this.getConn().WriteMsg(msg)
+}
+
+func (_this *client) SendMsg(id int, data interface{}) {
+	_this.getConn().SendMsg(id, data)
+}
+
+func (_this *client) Call(id int, data interface{}, timeout time.Duration) (interface{}, error) {
+	return _this.getConn().Call(id, data, timeout)
+}
+
+func (_this *client) CallAsync(id int, data interface{}, timeout time.Duration) <-chan CallResult {
+	return _this.getConn().CallAsync(id, data, timeout)
+}
+
+func (_this *client) QueryAttr() *sync.Map {
+	return _this.getConn().QueryAttr()
+}
+
+func (_this *client) getConn() IConn {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	return _this.conn
+}
+
+// 连接断开后按设置自动重连, 每次失败后等待时间翻倍, 最大为 maxDelay
+func (_this *client) keepAlive() {
+	for {
+		select {
+		case <-_this.exit:
+			return
+		case <-connDone(_this.getConn()):
+		}
+
+		_this.mu.RLock()
+		delay, maxDelay := _this.minDelay, _this.maxDelay
+		_this.mu.RUnlock()
+
+		// 不重连, 连接断开后客户端不再使用
+		if delay <= 0 {
+			_this.release()
+			return
+		}
+
+		for {
+			select {
+			case <-_this.exit:
+				return
+			case <-time.After(delay):
+			}
+
+			conn, err := _this.dial(_this.getConn())
+			if err == nil {
+				_this.mu.Lock()
+				select {
+				case <-_this.exit:
+					// 重连期间客户端已停止, 关闭新的连接
+					_this.mu.Unlock()
+					discardConn(conn)
+					return
+				default:
+				}
+				_this.conn = conn
+				_this.mu.Unlock()
+
+				logs.System("client reconnect success, remote addr:", conn.GetRemoteAddr())
+				conn.Start()
+				break
+			}
+
+			if delay *= 2; delay > maxDelay {
+				delay = maxDelay
+			}
+			logs.Error("client reconnect err:", err, "retry after:", delay)
+		}
+	}
+}
+
+// 客户端配置被客户端引用, 第一个客户端时启动 worker 池
+func (_this *socket) retainClient() {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.clients++
+	_this.startWorkers()
+}
+
+// 客户端不再使用时释放, 最后一个客户端释放后关闭 worker 池并取消指标采集, 之后再次连接时重新启动
+func (_this *socket) releaseClient() {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.clients--; _this.clients > 0 {
+		return
+	}
+
+	_this.workers = _this.workers.reset()
+	if _this.unregMetrics != nil {
+		_this.unregMetrics()
+		_this.unregMetrics = nil
+	}
+	_this.startOnce = sync.Once{}
+}
+
+// 关闭未启动的连接, 不回调连接的回调函数
+func discardConn(conn IConn) {
+	if c, ok := conn.(interface{ discard() }); ok {
+		c.discard()
+	}
+}
+
+// 连接断开信号
+func connDone(conn IConn) <-chan bool {
+	if c, ok := conn.(interface{ done() <-chan bool }); ok {
+		return c.done()
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package _net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// 编码消息内容
+// []byte, string 不经过编解码, 直接发送
+func (_this *socket) marshal(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, nil
+	}
+
+	return _this.getCodec().Marshal(data)
+}
+
+// 解码消息内容
+// msgID 未通过 RegMsg 注册类型时, 返回原始的 []byte
+func (_this *socket) unmarshal(msgID uint32, data []byte) (interface{}, error) {
+	return _this.decode(&_this.msgTypes, msgID, data)
+}
+
+// 解码应答内容
+// msgID 未通过 RegReply 注册类型时, 返回原始的 []byte
+func (_this *socket) unmarshalReply(msgID uint32, data []byte) (interface{}, error) {
+	return _this.decode(&_this.replyTypes, msgID, data)
+}
+
+// 按 types 中注册的类型解码
+func (_this *socket) decode(types *sync.Map, msgID uint32, data []byte) (interface{}, error) {
+	t, ok := types.Load(msgID)
+	if !ok {
+		return data, nil
+	}
+
+	v := reflect.New(t.(reflect.Type)).Interface()
+	if err := _this.getCodec().Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (_this *socket) getCodec() ICodec {
+	if _this.codec != nil {
+		return _this.codec
+	}
+
+	return binaryCodec{order: _this.byteOrder}
+}
+
+// json 编解码
+func NewJsonCodec() ICodec {
+	return jsonCodec{}
+}
+
+// gob 编解码, 双端都需要是 go 程序
+func NewGobCodec() ICodec {
+	return gobCodec{}
+}
+
+// 原始字节, 只支持 []byte 和 string, 解码时 v 需为 *[]byte
+func NewRawCodec() ICodec {
+	return rawCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(data interface{}) ([]byte, error) {
+	buff := bytes.NewBuffer([]byte{})
+	if err := gob.NewEncoder(buff).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("raw codec unsupported type: %T", data)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec unsupported type: %T", v)
+	}
+
+	*p = data
+	return nil
+}
+
+// 未设置编解码时的默认方式, 按 socket 的字节顺序读写固定长度的数据, 如 int32, 只包含固定长度字段的结构体
+type binaryCodec struct {
+	order binary.ByteOrder
+}
+
+func (_this binaryCodec) Marshal(data interface{}) ([]byte, error) {
+	buff := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buff, _this.order, data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (_this binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	return binary.Read(bytes.NewReader(data), _this.order, v)
+}
@@ -0,0 +1,125 @@
+package _net
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// 解压后的长度最多为 packetMaxSize 的倍数, 防止恶意构造的压缩数据占满内存
+const decompressRatio = 64
+
+func (_this *socket) SetCompress(compress Compression, threshold int) {
+	_this.compress = compress
+	_this.compressThreshold = threshold
+}
+
+// 压缩超过阈值的消息体, 返回压缩后的消息体和是否压缩
+func (_this *socket) compressBody(body []byte) ([]byte, bool, error) {
+	if _this.compress == CompressNone || len(body) < _this.compressThreshold || len(body) == 0 {
+		return body, false, nil
+	}
+
+	buff := &bytes.Buffer{}
+	w, err := newCompressWriter(_this.compress, buff)
+	if err != nil {
+		return nil, false, err
+	}
+	defer putCompressWriter(_this.compress, w)
+
+	if _, err = w.Write(body); err != nil {
+		return nil, false, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, false, err
+	}
+
+	// 压缩后更长时发送原始数据
+	if buff.Len() >= len(body) {
+		return body, false, nil
+	}
+
+	return buff.Bytes(), true, nil
+}
+
+// 解压消息体
+func (_this *socket) decompressBody(body []byte) ([]byte, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+
+	switch _this.compress {
+	case CompressFlate:
+		r = flate.NewReader(bytes.NewReader(body))
+	case CompressZlib:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case CompressGzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errors.New("compressed msg received, but compress not set")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _this.packetMaxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	limit := int64(_this.packetMaxSize) * decompressRatio
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("decompressed msg data long, limit: %d", limit)
+	}
+
+	return data, nil
+}
+
+// 压缩器可以通过 Reset 重复使用, 创建 flate 压缩器的开销较大
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+var compressPools = map[Compression]*sync.Pool{
+	CompressFlate: {},
+	CompressZlib:  {},
+	CompressGzip:  {},
+}
+
+func newCompressWriter(compress Compression, buff io.Writer) (compressWriter, error) {
+	pool, ok := compressPools[compress]
+	if !ok {
+		return nil, fmt.Errorf("compress unknown: %d", compress)
+	}
+
+	if w, ok := pool.Get().(compressWriter); ok {
+		w.Reset(buff)
+		return w, nil
+	}
+
+	switch compress {
+	case CompressFlate:
+		return flate.NewWriter(buff, flate.DefaultCompression)
+	case CompressZlib:
+		return zlib.NewWriter(buff), nil
+	default:
+		return gzip.NewWriter(buff), nil
+	}
+}
+
+func putCompressWriter(compress Compression, w compressWriter) {
+	compressPools[compress].Put(w)
+}
@@ -0,0 +1,336 @@
+package _net
+
+import (
+	"github.com/fly-way/gofly/logs"
+	"net"
+	"sync"
+	"time"
+)
+
+// 连接的传输层, tcp, websocket, kcp 各自实现消息和握手消息的读写
+type connTransport interface {
+	// 读取一帧, 返回消息头和解密后的消息体
+	readFrame(c *connCore) (PacketHead, []byte, error)
+	// 写入一帧
+	writeFrame(msg []byte) error
+	// 开始循环读取消息前调用
+	startRead(c *connCore)
+	readHandshake() ([]byte, error)
+	writeHandshake(msg []byte) error
+	// 设置握手的超时时间, 为零值时取消
+	setHandshakeDeadline(t time.Time)
+	setReadDeadline(t time.Time)
+	setWriteDeadline(t time.Time)
+	remoteAddr() net.Addr
+	close()
+}
+
+// 连接, 各传输层共用启动, 停止, 收发和会话的处理
+type connCore struct {
+	id        int
+	serve     *socket
+	transport connTransport
+	packer    IPacker
+	attr      sync.Map
+	msgChan   chan []byte
+	exit      chan bool
+	once      sync.Once
+	reason    error
+	calls     *callManager
+	limiter   *floodLimiter
+	cipher    *connCipher
+	// 优雅关闭使用, 通知 writer 写完缓存中的消息后退出
+	flushChan chan bool
+	flushOnce sync.Once
+	readDone  chan bool
+	writeDone chan bool
+	// websocket 服务端升级前已经检查 ip 并计入连接数
+	upgraded bool
+}
+
+func newConnCore(id int, serve *socket, transport connTransport) *connCore {
+	return &connCore{
+		id:        id,
+		serve:     serve,
+		transport: transport,
+		packer:    serve.getPacker(),
+		msgChan:   make(chan []byte, serve.sendQueueSize),
+		exit:      make(chan bool),
+		calls:     newCallManager(),
+		limiter:   serve.newFloodLimiter(),
+		cipher:    &connCipher{},
+		flushChan: make(chan bool),
+		readDone:  make(chan bool),
+		writeDone: make(chan bool),
+	}
+}
+
+func (_this *connCore) Start() {
+	// 启动前已经停止, 如客户端重连期间调用了 Stop
+	select {
+	case <-_this.exit:
+		return
+	default:
+	}
+
+	// socket 关闭中或连接被拒绝, 在连接回调之前关闭
+	admit := _this.serve.admit
+	if _this.upgraded {
+		admit = _this.serve.admitUpgraded
+	}
+
+	if !admit(_this) {
+		_this.transport.close()
+		return
+	}
+
+	// 握手, 恢复会话和认证成功后才开始处理消息
+	if err := _this.serve.accept(_this); err != nil {
+		logs.Error(err, "remote addr:", _this.GetRemoteAddr())
+		_this.serve.ipFilter.release(addrIP(_this.GetRemoteAddr()))
+		_this.transport.close()
+		return
+	}
+
+	// 握手或认证期间开始了 Shutdown, 在连接回调之前关闭
+	if !_this.serve.conns.add(_this) {
+		_this.serve.abortSession(_this)
+		_this.serve.ipFilter.release(addrIP(_this.GetRemoteAddr()))
+		_this.transport.close()
+		return
+	}
+	connOpened.Inc(_this.serve.name)
+	go _this.writer()
+
+	// 恢复会话时先发送缓存的消息, 回调 connResume 代替 connStart
+	if _this.serve.attachSession(_this) {
+		if _this.serve.connResume != nil {
+			_this.serve.connResume(_this)
+		}
+	} else if _this.serve.connStart != nil {
+		_this.serve.connStart(_this)
+	}
+
+	go _this.reader()
+}
+
+func (_this *connCore) Stop() {
+	_this.stop(ErrConnStopped)
+}
+
+// 停止连接, reason 为断开原因
+func (_this *connCore) stop(reason error) {
+	_this.once.Do(func() {
+		_this.reason = reason
+		_this.serve.conns.remove(_this.id)
+		_this.serve.ipFilter.release(addrIP(_this.GetRemoteAddr()))
+		connClosed.Inc(_this.serve.name)
+
+		// 保留会话时, 会话结束后才回调 connStop
+		keep := _this.serve.releaseSession(_this, reason)
+		if !keep && _this.serve.connStop != nil {
+			_this.serve.connStop(_this)
+		}
+
+		close(_this.exit)
+		_this.calls.close(reason)
+		if keep {
+			_this.serve.detachSession(_this, _this.msgChan)
+		} else {
+			_this.serve.groups.leaveAll(_this.id)
+		}
+		_this.transport.close()
+	})
+}
+
+// 关闭未启动的连接
+func (_this *connCore) discard() {
+	_this.transport.close()
+}
+
+func (_this *connCore) GetConnID() int {
+	return _this.id
+}
+
+func (_this *connCore) GetRemoteAddr() net.Addr {
+	return _this.transport.remoteAddr()
+}
+
+func (_this *connCore) GetStopReason() error {
+	return _this.reason
+}
+
+func (_this *connCore) WriteMsg(msg []byte) {
+	_this.serve.enqueue(_this, _this.msgChan, _this.exit, _this.writeDone, msg)
+}
+
+func (_this *connCore) SendMsg(id int, data interface{}) {
+	if err := _this.sendHead(PacketHead{ID: uint32(id)}, data); err != nil {
+		logs.Error("sendMsg err:", err, "msg id:", id)
+	}
+}
+
+func (_this *connCore) Call(id int, data interface{}, timeout time.Duration) (interface{}, error) {
+	result := <-_this.CallAsync(id, data, timeout)
+	return result.Data, result.Err
+}
+
+func (_this *connCore) CallAsync(id int, data interface{}, timeout time.Duration) <-chan CallResult {
+	return _this.serve.call(_this, _this.calls, id, data, timeout)
+}
+
+// 按消息头发送消息
+func (_this *connCore) sendHead(head PacketHead, data interface{}) error {
+	msg, err := _this.serve.packMsg(_this.packer, _this.cipher, head, data)
+	if err != nil {
+		return err
+	}
+
+	_this.WriteMsg(msg)
+	return nil
+}
+
+// 等待应答的请求
+func (_this *connCore) getCalls() *callManager {
+	return _this.calls
+}
+
+// 限流状态
+func (_this *connCore) getLimiter() *floodLimiter {
+	return _this.limiter
+}
+
+func (_this *connCore) QueryAttr() *sync.Map {
+	return &_this.attr
+}
+
+// 连接断开信号
+func (_this *connCore) done() <-chan bool {
+	return _this.exit
+}
+
+func (_this *connCore) reader() {
+	err := _this.readLoop()
+	close(_this.readDone)
+
+	// socket 关闭中, 由 Shutdown 在写完剩余消息后停止连接
+	if _this.serve.isClosing() {
+		return
+	}
+
+	_this.stop(err)
+}
+
+// 循环读取消息, 返回值为断开原因
+func (_this *connCore) readLoop() error {
+	_this.transport.startRead(_this)
+
+	for {
+		if _this.serve.isClosing() {
+			return ErrServerShutdown
+		}
+
+		_this.refreshReadDeadline()
+
+		head, body, err := _this.readFrame()
+		if err != nil {
+			return err
+		}
+
+		if err = _this.serve.handleMsg(_this, head, body); err != nil {
+			logs.Error(err)
+			return err
+		}
+	}
+}
+
+// 读取一帧, 返回消息头和解密后的消息体
+func (_this *connCore) readFrame() (PacketHead, []byte, error) {
+	return _this.transport.readFrame(_this)
+}
+
+// 刷新读超时时间
+func (_this *connCore) refreshReadDeadline() {
+	if _this.serve.readTimeout > 0 {
+		_this.transport.setReadDeadline(time.Now().Add(_this.serve.readTimeout))
+	}
+}
+
+func (_this *connCore) writer() {
+	defer close(_this.writeDone)
+
+	for {
+		select {
+		case <-_this.exit:
+			return
+		case <-_this.flushChan:
+			for {
+				select {
+				case msg := <-_this.msgChan:
+					if err := _this.write(msg); err != nil {
+						_this.stop(err)
+						return
+					}
+				default:
+					return
+				}
+			}
+		case msg := <-_this.msgChan:
+			if err := _this.write(msg); err != nil {
+				_this.stop(err)
+				return
+			}
+		}
+	}
+}
+
+// 写入一条消息
+func (_this *connCore) write(msg []byte) error {
+	if _this.serve.writeTimeout > 0 {
+		_this.transport.setWriteDeadline(time.Now().Add(_this.serve.writeTimeout))
+	}
+
+	if err := _this.transport.writeFrame(msg); err != nil {
+		return writeErr(err)
+	}
+
+	_this.serve.countFrameOut(len(msg))
+	return nil
+}
+
+func (_this *connCore) readHandshake() ([]byte, error) {
+	return _this.transport.readHandshake()
+}
+
+func (_this *connCore) writeHandshake(msg []byte) error {
+	return _this.transport.writeHandshake(msg)
+}
+
+func (_this *connCore) setHandshakeDeadline(t time.Time) {
+	_this.transport.setHandshakeDeadline(t)
+}
+
+// 连接的加密状态
+func (_this *connCore) getCipher() *connCipher {
+	return _this.cipher
+}
+
+// 接管会话的连接ID和加密状态
+func (_this *connCore) takeover(id int, cipher *connCipher) {
+	_this.id = id
+	_this.cipher = cipher
+}
+
+// 停止读取消息, 返回 reader 退出信号
+func (_this *connCore) stopRead() <-chan bool {
+	_this.transport.setReadDeadline(time.Now())
+	return _this.readDone
+}
+
+// 写完缓存中的消息后停止 writer, 返回 writer 退出信号
+func (_this *connCore) flush() <-chan bool {
+	_this.flushOnce.Do(func() {
+		close(_this.flushChan)
+	})
+	return _this.writeDone
+}
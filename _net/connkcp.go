@@ -0,0 +1,70 @@
+package _net
+
+import (
+	"github.com/fly-way/gofly/logs"
+	"net"
+	"time"
+)
+
+// kcp 传输层, 一条 kcp 消息为一帧
+type connKcp struct {
+	conn *kcpSession
+}
+
+func newConnKcp(id int, serve *socket, conn *kcpSession) IConn {
+	return newConnCore(id, serve, &connKcp{conn: conn})
+}
+
+func (_this *connKcp) readFrame(c *connCore) (PacketHead, []byte, error) {
+	data, err := _this.conn.ReadMessage()
+	if err != nil {
+		return PacketHead{}, nil, readErr(err)
+	}
+	c.serve.countFrameIn(len(data))
+
+	head, body, err := c.serve.unpackMsg(c.packer, c.cipher, data)
+	if err != nil {
+		logs.Error("Unpack err:", err)
+		return PacketHead{}, nil, err
+	}
+
+	return head, body, nil
+}
+
+func (_this *connKcp) writeFrame(msg []byte) error {
+	return _this.conn.WriteMessage(msg)
+}
+
+func (_this *connKcp) startRead(*connCore) {
+}
+
+// 读取握手消息, 一条 kcp 消息为一条握手消息
+func (_this *connKcp) readHandshake() ([]byte, error) {
+	return _this.conn.ReadMessage()
+}
+
+// 写入握手消息
+func (_this *connKcp) writeHandshake(msg []byte) error {
+	return _this.conn.WriteMessage(msg)
+}
+
+func (_this *connKcp) setHandshakeDeadline(t time.Time) {
+	_this.conn.SetReadDeadline(t)
+	_this.conn.SetWriteDeadline(t)
+}
+
+func (_this *connKcp) setReadDeadline(t time.Time) {
+	_this.conn.SetReadDeadline(t)
+}
+
+func (_this *connKcp) setWriteDeadline(t time.Time) {
+	_this.conn.SetWriteDeadline(t)
+}
+
+func (_this *connKcp) remoteAddr() net.Addr {
+	return _this.conn.RemoteAddr()
+}
+
+func (_this *connKcp) close() {
+	_this.conn.Close()
+}
@@ -1,191 +1,116 @@
 package _net
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"github.com/fly-way/gofly/logs"
-	"github.com/fly-way/gofly/utils/encrypt"
 	"io"
 	"net"
-	"sync"
+	"time"
 )
 
+// tcp 传输层, 按消息头的 len 字段划分消息
 type connTcp struct {
-	id       int
-	serve    *socket
-	conn     *net.TCPConn
-	attr     sync.Map
-	msgChan  chan []byte
+	conn     net.Conn
 	headPool []byte
-	exit     chan bool
 }
 
-type msgTcp struct {
-	len  uint32
-	id   uint32
-	data interface{}
-}
-
-func newConnTcp(id int, serve *socket, conn *net.TCPConn) IConn {
-	return &connTcp{
-		id:       id,
-		serve:    serve,
+func newConnTcp(id int, serve *socket, conn net.Conn) IConn {
+	return newConnCore(id, serve, &connTcp{
 		conn:     conn,
-		msgChan:  make(chan []byte, 4096),
-		headPool: make([]byte, 8 /*len uint32 + id uint32*/),
-		exit:     make(chan bool),
-	}
-}
-
-func (_this *connTcp) Start() {
-	if _this.serve.connStart != nil {
-		_this.serve.connStart(_this)
-	}
-
-	go _this.reader()
-	go _this.writer()
+		headPool: make([]byte, serve.getPacker().HeadLen()),
+	})
 }
 
-func (_this *connTcp) Stop() {
-	if _this.serve.connStop != nil {
-		_this.serve.connStop(_this)
+// 读取一帧, 消息体单独加密, 消息头作为附加数据
+func (_this *connTcp) readFrame(c *connCore) (PacketHead, []byte, error) {
+	if _, err := io.ReadFull(_this.conn, _this.headPool); err != nil {
+		return PacketHead{}, nil, readErr(err)
 	}
 
-	close(_this.exit)
-	close(_this.msgChan)
-}
-
-func (_this *connTcp) GetConnID() int {
-	return _this.id
-}
-
-func (_this *connTcp) GetRemoteAddr() net.Addr {
-	return _this.conn.RemoteAddr()
-}
-
-func (_this *connTcp) WriteMsg(msg []byte) {
-	select {
-	case <-_this.exit:
-		return
-	default:
-		_this.msgChan <- msg
+	head, err := c.packer.UnPack(_this.headPool, c.serve.byteOrder)
+	if err != nil {
+		logs.Error("unpack err:", err)
+		return PacketHead{}, nil, err
 	}
-}
 
-func (_this *connTcp) SendMsg(id int, data interface{}) {
-	v, ok := data.([]byte)
-	if !ok {
-		logs.Error("msg data not []byte, data:", data)
-		return
+	if c.serve.packetMaxSize > 0 && int(head.Len) > c.serve.packetMaxSize {
+		logs.Error("unpack err: msg data long, len:", head.Len)
+		return PacketHead{}, nil, errors.New("msg data long")
 	}
 
-	msgTcp := &msgTcp{len: uint32(len(v)), id: uint32(id), data: v}
-	if _this.serve.key != "" {
-		if msg, err := encrypt.AesEncrypt(v, []byte(_this.serve.key), []byte(_this.serve.iv)); err != nil {
-			logs.Error("sendMsg aes encrypt err:", err)
-			return
-		} else {
-			msgTcp.data = msg
+	var data []byte
+	if head.Len > 0 {
+		data = make([]byte, head.Len)
+		if _, err := io.ReadFull(_this.conn, data); err != nil {
+			logs.Error("read msg data err:", err)
+			return PacketHead{}, nil, readErr(err)
 		}
 	}
 
-	msg, err := _this.pack(msgTcp)
-	if err != nil {
-		logs.Error("pack err:", err, "msg id:", id)
-		return
-	}
+	c.serve.countFrameIn(len(_this.headPool) + len(data))
 
-	_this.WriteMsg(msg)
-}
+	if data, err = c.serve.decrypt(c.cipher, data, headAAD(head)); err != nil {
+		logs.Error("Decrypt err, close connection ... err:", err)
+		return PacketHead{}, nil, err
+	}
 
-func (_this *connTcp) QueryAttr() *sync.Map {
-	return &_this.attr
+	return head, data, nil
 }
 
-func (_this *connTcp) reader() {
-	defer _this.Stop()
-
-	for {
-		if _, err := io.ReadFull(_this.conn, _this.headPool); err != nil {
-			return
-		}
-
-		msg, err := _this.unPack(_this.headPool)
-		if err != nil {
-			logs.Error("unpack err:", err)
-			return
-		}
-
-		var data []byte
-		if msg.len > 0 {
-			data = make([]byte, msg.len)
-			if _, err := io.ReadFull(_this.conn, data); err != nil {
-				logs.Error("read msg data err:", err)
-				return
-			}
-		}
-
-		if _this.serve.key != "" {
-			if data, err = encrypt.AesDecrypt(data, []byte(_this.serve.key), []byte(_this.serve.iv)); err != nil {
-				logs.Error("Decrypt err, close connection ... data:", string(data), "err:", err)
-				return
-			}
-		}
-
-		go _this.serve.workers.addTask(Request{Conn: _this, ID: msg.id, Data: data})
+func (_this *connTcp) writeFrame(msg []byte) error {
+	if _, err := _this.conn.Write(msg); err != nil {
+		logs.Error("send data err:", err, " conn writer exit")
+		return err
 	}
-}
 
-func (_this *connTcp) writer() {
-	for {
-		select {
-		case <-_this.exit:
-			return
-		default:
-			if _, err := _this.conn.Write(<-_this.msgChan); err != nil {
-				logs.Error("send data err:", err, " conn writer exit")
-				return
-			}
-		}
-	}
+	return nil
 }
 
-func (_this *connTcp) pack(msg *msgTcp) ([]byte, error) {
-	buff := bytes.NewBuffer([]byte{})
+func (_this *connTcp) startRead(*connCore) {
+}
 
-	if err := binary.Write(buff, _this.serve.byteOrder, msg.len); err != nil {
+// 读取握手消息, 格式为 len uint16 + 消息
+func (_this *connTcp) readHandshake() ([]byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(_this.conn, head); err != nil {
 		return nil, err
 	}
 
-	if err := binary.Write(buff, _this.serve.byteOrder, msg.id); err != nil {
+	msg := make([]byte, binary.BigEndian.Uint16(head))
+	if _, err := io.ReadFull(_this.conn, msg); err != nil {
 		return nil, err
 	}
 
-	if err := binary.Write(buff, _this.serve.byteOrder, msg.data); err != nil {
-		return nil, err
-	}
+	return msg, nil
+}
 
-	return buff.Bytes(), nil
+// 写入握手消息
+func (_this *connTcp) writeHandshake(msg []byte) error {
+	buff := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buff, uint16(len(msg)))
+	copy(buff[2:], msg)
+
+	_, err := _this.conn.Write(buff)
+	return err
 }
 
-func (_this *connTcp) unPack(data []byte) (*msgTcp, error) {
-	var (
-		msg      = msgTcp{}
-		dataBuff = bytes.NewReader(data)
-	)
+func (_this *connTcp) setHandshakeDeadline(t time.Time) {
+	_this.conn.SetDeadline(t)
+}
 
-	if err := binary.Read(dataBuff, _this.serve.byteOrder, &msg.len); err != nil {
-		return nil, err
-	}
+func (_this *connTcp) setReadDeadline(t time.Time) {
+	_this.conn.SetReadDeadline(t)
+}
 
-	if _this.serve.packetMaxSize > 0 && int(msg.len) > _this.serve.packetMaxSize {
-		return nil, errors.New("msg data long")
-	}
+func (_this *connTcp) setWriteDeadline(t time.Time) {
+	_this.conn.SetWriteDeadline(t)
+}
 
-	if err := binary.Read(dataBuff, _this.serve.byteOrder, &msg.id); err != nil {
-		return nil, err
-	}
+func (_this *connTcp) remoteAddr() net.Addr {
+	return _this.conn.RemoteAddr()
+}
 
-	return &msg, nil
+func (_this *connTcp) close() {
+	_this.conn.Close()
 }
@@ -1,167 +1,82 @@
 package _net
 
 import (
-	"bytes"
-	"encoding/binary"
-	"errors"
 	"github.com/fly-way/gofly/logs"
-	"github.com/fly-way/gofly/utils/encrypt"
 	"github.com/gorilla/websocket"
 	"net"
-	"sync"
+	"time"
 )
 
+// websocket 传输层, 一条 websocket 消息为一帧
 type connWebsocket struct {
-	id      int
-	serve   *socket
-	conn    *websocket.Conn
-	attr    sync.Map
-	msgChan chan []byte
-	exit    chan bool
-}
-
-type msgWs struct {
-	id   uint32
-	data interface{}
+	conn *websocket.Conn
 }
 
 func newConnWebsocket(id int, serve *socket, conn *websocket.Conn) IConn {
-	return &connWebsocket{
-		id:      id,
-		serve:   serve,
-		conn:    conn,
-		msgChan: make(chan []byte, 4096),
-		exit:    make(chan bool),
-	}
+	return newConnCore(id, serve, &connWebsocket{conn: conn})
 }
 
-func (_this *connWebsocket) Start() {
-	if _this.serve.connStart != nil {
-		_this.serve.connStart(_this)
+func (_this *connWebsocket) readFrame(c *connCore) (PacketHead, []byte, error) {
+	_, data, err := _this.conn.ReadMessage()
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok {
+			if !netErr.Timeout() {
+				logs.Debug("ReadMessage error:", err)
+			}
+		}
+		return PacketHead{}, nil, readErr(err)
 	}
+	c.serve.countFrameIn(len(data))
 
-	go _this.reader()
-	go _this.writer()
-}
-
-func (_this *connWebsocket) Stop() {
-	if _this.serve.connStop != nil {
-		_this.serve.connStop(_this)
+	head, body, err := c.serve.unpackMsg(c.packer, c.cipher, data)
+	if err != nil {
+		logs.Error("Unpack err:", err)
+		return PacketHead{}, nil, err
 	}
 
-	close(_this.exit)
-	close(_this.msgChan)
+	return head, body, nil
 }
 
-func (_this *connWebsocket) GetConnID() int {
-	return _this.id
+func (_this *connWebsocket) writeFrame(msg []byte) error {
+	return _this.conn.WriteMessage(websocket.BinaryMessage, msg)
 }
 
-func (_this *connWebsocket) GetRemoteAddr() net.Addr {
-	return _this.conn.RemoteAddr()
+// websocket 的 ping 控制帧同样视为连接活跃
+func (_this *connWebsocket) startRead(c *connCore) {
+	_this.conn.SetPingHandler(func(appData string) error {
+		c.refreshReadDeadline()
+		return _this.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+	})
 }
 
-func (_this *connWebsocket) WriteMsg(msg []byte) {
-	select {
-	case <-_this.exit:
-		return
-	default:
-		_this.msgChan <- msg
-	}
+// 读取握手消息, 一条 websocket 消息为一条握手消息
+func (_this *connWebsocket) readHandshake() ([]byte, error) {
+	_, msg, err := _this.conn.ReadMessage()
+	return msg, err
 }
 
-func (_this *connWebsocket) SendMsg(id int, data interface{}) {
-	msg, err := _this.pack(&msgWs{uint32(id), data})
-	if err != nil {
-		logs.Error("pack err:", err, "msg id:", id)
-		return
-	}
-
-	if _this.serve.key != "" {
-		if msg, err = encrypt.AesEncrypt(msg, []byte(_this.serve.key), []byte(_this.serve.iv)); err != nil {
-			logs.Error("sendMsg aes encrypt err:", err)
-			return
-		}
-	}
-
-	_this.WriteMsg(msg)
+// 写入握手消息
+func (_this *connWebsocket) writeHandshake(msg []byte) error {
+	return _this.conn.WriteMessage(websocket.BinaryMessage, msg)
 }
 
-func (_this *connWebsocket) QueryAttr() *sync.Map {
-	return &_this.attr
+func (_this *connWebsocket) setHandshakeDeadline(t time.Time) {
+	_this.conn.SetReadDeadline(t)
+	_this.conn.SetWriteDeadline(t)
 }
 
-func (_this *connWebsocket) reader() {
-	defer _this.Stop()
-
-	var (
-		data []byte
-		err  error
-	)
-
-	for {
-		if _, data, err = _this.conn.ReadMessage(); err != nil {
-			if netErr, ok := err.(net.Error); ok {
-				if !netErr.Timeout() {
-					logs.Debug("ReadMessage error:", err)
-				}
-			}
-			return
-		}
-
-		if _this.serve.key != "" {
-			if data, err = encrypt.AesDecrypt(data, []byte(_this.serve.key), []byte(_this.serve.iv)); err != nil {
-				logs.Error("Decrypt err, close connection ... data:", string(data), "err:", err)
-				return
-			}
-		}
-
-		var msg *msgWs
-		if msg, err = _this.unPack(data); err != nil {
-			logs.Error("Unpack err:", err)
-			return
-		}
-
-		go _this.serve.workers.addTask(Request{Conn: _this, ID: msg.id, Data: msg.data})
-	}
+func (_this *connWebsocket) setReadDeadline(t time.Time) {
+	_this.conn.SetReadDeadline(t)
 }
 
-func (_this *connWebsocket) writer() {
-	for {
-		select {
-		case <-_this.exit:
-			return
-		default:
-			if err := _this.conn.WriteMessage(websocket.BinaryMessage, <-_this.msgChan); err != nil {
-				return
-			}
-		}
-	}
+func (_this *connWebsocket) setWriteDeadline(t time.Time) {
+	_this.conn.SetWriteDeadline(t)
 }
 
-func (_this *connWebsocket) pack(msg *msgWs) ([]byte, error) {
-	buff := bytes.NewBuffer([]byte{})
-
-	if err := binary.Write(buff, _this.serve.byteOrder, msg.id); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(buff, _this.serve.byteOrder, msg.data); err != nil {
-		return nil, err
-	}
-
-	return buff.Bytes(), nil
+func (_this *connWebsocket) remoteAddr() net.Addr {
+	return _this.conn.RemoteAddr()
 }
 
-func (_this *connWebsocket) unPack(data []byte) (*msgWs, error) {
-	size := len(data)
-	if size < 4 {
-		return nil, errors.New("msg data short")
-	}
-
-	if _this.serve.packetMaxSize > 0 && size > _this.serve.packetMaxSize {
-		return nil, errors.New("msg data long")
-	}
-
-	return &msgWs{_this.serve.byteOrder.Uint32(data[:4]), data[4:]}, nil
+func (_this *connWebsocket) close() {
+	_this.conn.Close()
 }
@@ -0,0 +1,61 @@
+package _net
+
+import (
+	"errors"
+	"net"
+)
+
+// 连接断开原因, 可在 SetConnStopCall 的回调中通过 IConn.GetStopReason 获取
+// 除以下原因外, 也可能是读写连接时产生的原始错误, 如 io.EOF
+var (
+	// 服务端主动调用 Stop 关闭连接
+	ErrConnStopped = errors.New("conn stopped")
+	// 被 ISocket.Kick 踢掉
+	ErrConnKicked = errors.New("conn kicked")
+	// 超过读超时时间未收到任何消息
+	ErrIdleTimeout = errors.New("conn idle timeout")
+	// 写消息超时
+	ErrWriteTimeout = errors.New("conn write timeout")
+	// 客户端读取过慢, 触发 BackpressureDisconnect 背压策略
+	ErrSlowConsumer = errors.New("conn slow consumer")
+	// 服务器关闭
+	ErrServerShutdown = errors.New("server shutdown")
+	// 每秒收到的消息数量超过 FloodLimit.MsgPerSecond
+	ErrMsgRateLimit = errors.New("conn msg rate limit")
+	// 每秒收到的字节数超过 FloodLimit.BytesPerSecond
+	ErrByteRateLimit = errors.New("conn byte rate limit")
+	// 等待处理的任务数量超过 FloodLimit.MaxPending
+	ErrPendingLimit = errors.New("conn pending limit")
+	// 恢复同一会话的新连接替换了原连接, 见 ISocket.SetResume
+	ErrConnResumed = errors.New("conn resumed by new conn")
+	// SetDispatchCall 设置的回调 panic
+	ErrDispatchPanic = errors.New("conn dispatch panic")
+	// 开启 SetReplayProtect 后收到重复或超出窗口的帧
+	ErrReplayFrame = errors.New("conn frame replayed")
+)
+
+// Call 请求的错误, 连接断开时返回断开原因
+var (
+	// 超过超时时间未收到应答
+	ErrCallTimeout = errors.New("call timeout")
+	// 未开启序列号, 见 ISocket.SetSeq
+	ErrSeqDisabled = errors.New("call seq disabled")
+)
+
+// 读取连接错误转换为断开原因
+func readErr(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrIdleTimeout
+	}
+
+	return err
+}
+
+// 写入连接错误转换为断开原因
+func writeErr(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrWriteTimeout
+	}
+
+	return err
+}
@@ -0,0 +1,245 @@
+package _net
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+func (_this *socket) SetFloodLimit(limit FloodLimit) {
+	_this.floodLimit = limit
+}
+
+func (_this *socket) SetFloodCall(call func(IConn, error)) {
+	_this.floodCall = call
+}
+
+// 单条连接的限流状态, 未设置任何限制时为 nil
+type floodLimiter struct {
+	limit FloodLimit
+	msg   *tokenBucket
+	bytes *tokenBucket
+	// 等待处理的任务, 容量为 MaxPending
+	pending chan bool
+}
+
+func (_this *socket) newFloodLimiter() *floodLimiter {
+	limit := _this.floodLimit
+	if limit.MsgPerSecond <= 0 && limit.BytesPerSecond <= 0 && limit.MaxPending <= 0 {
+		return nil
+	}
+
+	l := &floodLimiter{limit: limit}
+	if limit.MsgPerSecond > 0 {
+		l.msg = newTokenBucket(limit.MsgPerSecond)
+	}
+	if limit.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(limit.BytesPerSecond)
+	}
+	if limit.MaxPending > 0 {
+		l.pending = make(chan bool, limit.MaxPending)
+	}
+
+	return l
+}
+
+// 检查收到的消息是否超过频率限制, 在连接的 reader 中调用
+// 返回 false 表示丢弃该消息, 返回错误时需要断开连接
+func (_this *socket) checkRate(conn IConn, size int) (bool, error) {
+	l := connLimiter(conn)
+	if l == nil {
+		return true, nil
+	}
+
+	for _, v := range []struct {
+		bucket *tokenBucket
+		n      int
+		reason error
+	}{
+		{l.msg, 1, ErrMsgRateLimit},
+		{l.bytes, size, ErrByteRateLimit},
+	} {
+		if v.bucket == nil {
+			continue
+		}
+
+		wait := v.bucket.take(v.n, l.limit.Action == FloodDelay)
+		if wait == 0 {
+			continue
+		}
+
+		if l.limit.Action == FloodDelay {
+			// 延迟读取, 超出的部分由之后的时间补上
+			_this.onFlood(conn, v.reason)
+			select {
+			case <-connDone(conn):
+				return false, nil
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		return _this.flood(conn, v.reason)
+	}
+
+	return true, nil
+}
+
+// 占用一个等待处理的任务, 任务处理完成后调用 releaseTask
+// 返回 false 表示丢弃该消息, 返回错误时需要断开连接
+func (_this *socket) acquireTask(conn IConn) (bool, error) {
+	l := connLimiter(conn)
+	if l == nil || l.pending == nil {
+		return true, nil
+	}
+
+	select {
+	case l.pending <- true:
+		return true, nil
+	default:
+	}
+
+	if l.limit.Action != FloodDelay {
+		return _this.flood(conn, ErrPendingLimit)
+	}
+
+	// 等待已有的任务处理完成
+	_this.onFlood(conn, ErrPendingLimit)
+	select {
+	case <-connDone(conn):
+		return false, nil
+	case l.pending <- true:
+		return true, nil
+	}
+}
+
+// 任务处理完成
+func (_this *socket) releaseTask(conn IConn) {
+	if l := connLimiter(conn); l != nil && l.pending != nil {
+		<-l.pending
+	}
+}
+
+// 超过限制时按 FloodDrop, FloodKick, FloodBan 处理
+func (_this *socket) flood(conn IConn, reason error) (bool, error) {
+	_this.onFlood(conn, reason)
+
+	switch connLimiter(conn).limit.Action {
+	case FloodKick:
+		return false, reason
+	case FloodBan:
+		_this.bans.ban(addrIP(conn.GetRemoteAddr()), connLimiter(conn).limit.BanTime)
+		return false, reason
+	}
+
+	return false, nil
+}
+
+// 超过限制的回调
+func (_this *socket) onFlood(conn IConn, reason error) {
+	if _this.floodCall != nil {
+		_this.floodCall(conn, reason)
+	}
+}
+
+// 连接的限流状态
+func connLimiter(conn IConn) *floodLimiter {
+	if c, ok := conn.(interface{ getLimiter() *floodLimiter }); ok {
+		return c.getLimiter()
+	}
+
+	return nil
+}
+
+// 令牌桶, 每秒生成 rate 个令牌, 最多缓存 rate 个
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// 取出 n 个令牌, 返回令牌足够前需要等待的时间, 0 表示令牌足够
+// debt 为 true 时令牌不足也会取出, 由之后生成的令牌抵扣
+func (_this *tokenBucket) take(n int, debt bool) time.Duration {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	now := time.Now()
+	_this.tokens += now.Sub(_this.last).Seconds() * _this.rate
+	if _this.tokens > _this.rate {
+		_this.tokens = _this.rate
+	}
+	_this.last = now
+
+	if _this.tokens >= float64(n) {
+		_this.tokens -= float64(n)
+		return 0
+	}
+
+	wait := time.Duration((float64(n) - _this.tokens) / _this.rate * float64(time.Second))
+	if debt {
+		_this.tokens -= float64(n)
+	}
+
+	return wait
+}
+
+// 临时封禁的 ip
+type banList struct {
+	mu  sync.Mutex
+	ips map[string]time.Time
+}
+
+func newBanList() *banList {
+	return &banList{ips: make(map[string]time.Time)}
+}
+
+// 封禁 ip, d 为封禁时长
+func (_this *banList) ban(ip string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.ips[ip] = time.Now().Add(d)
+}
+
+// ip 是否处于封禁中, 顺带清理已过期的 ip
+func (_this *banList) banned(ip string) bool {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range _this.ips {
+		if now.After(v) {
+			delete(_this.ips, k)
+		}
+	}
+
+	_, ok := _this.ips[ip]
+	return ok
+}
+
+// 地址中的 ip
+func addrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+
+	return addr.String()
+}
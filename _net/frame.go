@@ -0,0 +1,190 @@
+package _net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fly-way/gofly/utils/encrypt"
+)
+
+// tcp 是字节流协议, 需要通过消息头中的长度拆分消息
+// websocket, kcp 自带消息边界
+func (_this *socket) isStream() bool {
+	return _this.network == "tcp"
+}
+
+// 编码一条消息, 返回可直接写入连接的一帧
+// 字节流协议只加密消息体, 消息头保持明文用于读取长度, 其余协议整帧加密
+func (_this *socket) packMsg(packer IPacker, cipher *connCipher, head PacketHead, data interface{}) ([]byte, error) {
+	body, err := _this.marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal err: %v", err)
+	}
+
+	// 先压缩再加密
+	body, compressed, err := _this.compressBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("compress err: %v", err)
+	}
+	if compressed {
+		head.Flags |= FlagCompressed
+	}
+
+	if _this.isStream() {
+		if body, err = _this.encrypt(cipher, body, headAAD(head)); err != nil {
+			return nil, err
+		}
+		return packer.Pack(head, body, _this.byteOrder)
+	}
+
+	msg, err := packer.Pack(head, body, _this.byteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	return _this.encrypt(cipher, msg, nil)
+}
+
+// 解析自带消息边界协议的一帧, 返回消息头和消息体
+func (_this *socket) unpackMsg(packer IPacker, cipher *connCipher, data []byte) (PacketHead, []byte, error) {
+	data, err := _this.decrypt(cipher, data, nil)
+	if err != nil {
+		return PacketHead{}, nil, err
+	}
+
+	if _this.packetMaxSize > 0 && len(data) > _this.packetMaxSize {
+		return PacketHead{}, nil, errors.New("msg data long")
+	}
+
+	head, err := packer.UnPack(data, _this.byteOrder)
+	if err != nil {
+		return PacketHead{}, nil, err
+	}
+
+	return head, data[packer.HeadLen():], nil
+}
+
+// 处理收到的一条消息, 返回错误时需要断开连接
+func (_this *socket) handleMsg(conn IConn, head PacketHead, body []byte) error {
+	if ok, err := _this.checkRate(conn, len(body)); !ok {
+		return err
+	}
+	_this.countMsg(head.ID)
+
+	// 解密后再解压
+	if head.Flags&FlagCompressed != 0 {
+		var err error
+		if body, err = _this.decompressBody(body); err != nil {
+			return fmt.Errorf("decompress err: %v, msg id: %d", err, head.ID)
+		}
+	}
+
+	// 心跳消息由框架直接应答, 不进入 worker 池
+	if _this.isHeartbeat(head.ID) {
+		return sendHead(conn, PacketHead{ID: head.ID, Seq: replySeq(head.Seq)}, body)
+	}
+
+	// 应答交给等待中的 Call, 不进入 worker 池
+	if head.Seq&replySeqFlag != 0 {
+		if err := _this.handleReply(conn, head, body); err != nil {
+			return fmt.Errorf("unmarshal reply err: %v, msg id: %d", err, head.ID)
+		}
+		return nil
+	}
+
+	data, err := _this.unmarshal(head.ID, body)
+	if err != nil {
+		return fmt.Errorf("unmarshal err: %v, msg id: %d", err, head.ID)
+	}
+
+	if ok, err := _this.acquireTask(conn); !ok {
+		return err
+	}
+
+	request := Request{Conn: conn, ID: head.ID, Seq: head.Seq, Key: conn.GetConnID(), Data: data}
+	if !_this.dispatch(&request) {
+		_this.releaseTask(conn)
+		return ErrDispatchPanic
+	}
+
+	_this.workers.addTask(request)
+	return nil
+}
+
+// 加密, 未设置 key 且未握手时原样返回
+// 握手成功的连接使用会话密钥的 gcm 模式, 否则使用 socket 设置的密钥
+// aad 只在 gcm 模式下参与认证, 用于保护明文的消息头
+func (_this *socket) encrypt(cipher *connCipher, data []byte, aad []byte) ([]byte, error) {
+	if cipher.key == nil && _this.key == "" {
+		return data, nil
+	}
+
+	// 计数器在密文中, 无法被篡改
+	if _this.replayProtect {
+		data = cipher.addCounter(data)
+	}
+
+	var (
+		msg []byte
+		err error
+	)
+	switch {
+	case cipher.key != nil:
+		msg, err = encrypt.GcmSeal(data, cipher.key, aad)
+	case _this.gcm:
+		msg, err = encrypt.GcmSeal(data, []byte(_this.key), aad)
+	default:
+		msg, err = encrypt.AesEncrypt(data, []byte(_this.key), []byte(_this.iv))
+	}
+
+	if err != nil {
+		cryptoErrors.Inc(_this.name, "encrypt")
+		return nil, fmt.Errorf("aes encrypt err: %v", err)
+	}
+
+	return msg, nil
+}
+
+// 解密, 未设置 key 且未握手时原样返回, gcm 模式下认证失败返回错误
+func (_this *socket) decrypt(cipher *connCipher, data []byte, aad []byte) ([]byte, error) {
+	if cipher.key == nil && _this.key == "" {
+		return data, nil
+	}
+
+	var (
+		msg []byte
+		err error
+	)
+	switch {
+	case cipher.key != nil:
+		msg, err = encrypt.GcmOpen(data, cipher.key, aad)
+	case _this.gcm:
+		msg, err = encrypt.GcmOpen(data, []byte(_this.key), aad)
+	default:
+		msg, err = encrypt.AesDecrypt(data, []byte(_this.key), []byte(_this.iv))
+	}
+
+	if err != nil {
+		cryptoErrors.Inc(_this.name, "decrypt")
+		return nil, fmt.Errorf("aes decrypt err: %v", err)
+	}
+
+	if _this.replayProtect {
+		if msg, err = cipher.checkCounter(msg); err != nil {
+			cryptoErrors.Inc(_this.name, "replay")
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// 字节流协议明文消息头的认证数据, 长度字段由密文长度保证
+func headAAD(head PacketHead) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint32(aad, head.ID)
+	binary.BigEndian.PutUint32(aad[4:], head.Seq)
+	aad[8] = head.Flags
+
+	return aad
+}
@@ -0,0 +1,137 @@
+package _net
+
+import "sync"
+
+// 分组管理器, 记录每个分组的成员以及每条连接加入的分组
+type groupManager struct {
+	mu     sync.RWMutex
+	groups map[string]map[int]IConn
+	joined map[int]map[string]bool
+}
+
+func newGroupManager() *groupManager {
+	return &groupManager{
+		groups: make(map[string]map[int]IConn),
+		joined: make(map[int]map[string]bool),
+	}
+}
+
+// 加入分组
+func (_this *groupManager) join(group string, conn IConn) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	id := conn.GetConnID()
+
+	members, ok := _this.groups[group]
+	if !ok {
+		members = make(map[int]IConn)
+		_this.groups[group] = members
+	}
+	members[id] = conn
+
+	groups, ok := _this.joined[id]
+	if !ok {
+		groups = make(map[string]bool)
+		_this.joined[id] = groups
+	}
+	groups[group] = true
+}
+
+// 离开分组, 分组没有成员时删除分组
+func (_this *groupManager) leave(group string, id int) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.remove(group, id)
+}
+
+// 离开加入的所有分组
+func (_this *groupManager) leaveAll(id int) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	for group := range _this.joined[id] {
+		_this.remove(group, id)
+	}
+}
+
+// 恢复会话的新连接替换原连接加入的所有分组
+func (_this *groupManager) rejoin(conn IConn) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	id := conn.GetConnID()
+	for group := range _this.joined[id] {
+		_this.groups[group][id] = conn
+	}
+}
+
+// 移除分组成员, 调用前需要加锁
+func (_this *groupManager) remove(group string, id int) {
+	if members, ok := _this.groups[group]; ok {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(_this.groups, group)
+		}
+	}
+
+	if groups, ok := _this.joined[id]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(_this.joined, id)
+		}
+	}
+}
+
+// 分组成员快照
+func (_this *groupManager) members(group string) []IConn {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	members := _this.groups[group]
+	conns := make([]IConn, 0, len(members))
+	for _, conn := range members {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
+
+func (_this *socket) Join(group string, conn IConn) {
+	_this.groups.join(group, conn)
+
+	// 连接已断开时不会再触发自动离开, 需要在这里移除
+	select {
+	case <-connDone(conn):
+		_this.groups.leave(group, conn.GetConnID())
+	default:
+	}
+}
+
+func (_this *socket) Leave(group string, conn IConn) {
+	_this.groups.leave(group, conn.GetConnID())
+}
+
+func (_this *socket) Members(group string) []IConn {
+	return _this.groups.members(group)
+}
+
+func (_this *socket) Broadcast(group string, msgID int, data interface{}, except ...IConn) {
+	for _, conn := range _this.groups.members(group) {
+		if !isExcept(conn, except) {
+			conn.SendMsg(msgID, data)
+		}
+	}
+}
+
+// 是否为排除的连接
+func isExcept(conn IConn, except []IConn) bool {
+	for _, v := range except {
+		if v != nil && v.GetConnID() == conn.GetConnID() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,13 +1,21 @@
 package _net
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/binary"
 	"net"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 func NewServer() IServer {
-	return &server{}
+	return &server{
+		done: make(chan bool),
+	}
 }
 
 type IServer interface {
@@ -17,6 +25,10 @@ type IServer interface {
 	// 初始化websocket服务对象
 	WebsocketServe() ISocket
 
+	// 初始化kcp服务对象, 基于 udp 的可靠有序传输, 适用于对延迟敏感的实时战斗
+	// 消息格式, 加密, 路由, worker 池与 websocket 一致
+	KcpServe() ISocket
+
 	// 初始化rpc服务对象
 	RpcServe() IRpc
 
@@ -25,8 +37,19 @@ type IServer interface {
 
 	// 注册 prof 性能分析监听
 	// port 端口
+	// /metrics 路径输出 Prometheus 文本格式的指标, 包括连接数, 收发字节数, 消息数, worker 队列长度, 处理耗时等
 	RegProfListen(port int)
 
+	// 注册管理接口监听, 在 Start 时开启, 用于线上排查问题
+	// host ip地址, port 端口, token 访问令牌, 请求需携带 Authorization: Bearer <token> 或 ?token=<token>
+	// 接口返回 json, socket 参数为 socket 名称, 如 "tcp://127.0.0.1:9999", 为空表示所有 socket:
+	//	GET  /admin/conns?socket=		在线连接列表, 包括连接地址和 QueryAttr 中的属性
+	//	POST /admin/kick?socket=&id=	踢掉连接, 连接ID只在 socket 内唯一, socket 为空且多个 socket 中都有该连接时返回 409
+	//	POST /admin/broadcast			广播消息, body 为 {"socket": "", "msg_id": 1, "data": ""}, data 按原始字节发送
+	//	POST /admin/debug?enable=		开启或关闭 debug 日志
+	//	GET  /admin/workers?socket=		worker 池状态
+	RegAdminListen(host string, port int, token string)
+
 	// 注册系统信号处理
 	// callback 处理系统信号的 callback
 	// sig 系统信号集合
@@ -35,8 +58,12 @@ type IServer interface {
 	// 启动服务
 	Start()
 
-	// 停止服务
+	// 停止服务, 等待所有 socket 优雅关闭后返回
 	Stop()
+
+	// 优雅关闭服务, 关闭所有 socket 及 prof 监听, 完成后 Start 返回
+	// ctx 超时后会强制断开剩余连接并返回 ctx.Err()
+	Shutdown(ctx context.Context) error
 }
 
 type ISocket interface {
@@ -44,16 +71,151 @@ type ISocket interface {
 	// 默认为不加密
 	AesEncrypt(key string, iv string)
 
+	// 设置 aes-gcm 加密 key, key 长度同 AesEncrypt
+	// 每帧使用随机 nonce 并带有认证标签, 被篡改的帧解密失败, 连接会被断开
+	// 与 AesEncrypt 二选一, 以最后设置的为准, AesEncrypt 保留给旧客户端使用
+	AesGcmEncrypt(key string)
+
+	// 服务端开启握手, key 为 P-256 的固定私钥, 客户端需要通过 SetHandshakePin 固定对应的公钥
+	// 连接建立后先用 ecdh 协商出每条连接独立的会话密钥, 之后的消息使用会话密钥 aes-256-gcm 加密
+	// 握手失败或超时的连接在 SetConnStartCall 的回调之前关闭, 不会处理任何消息
+	SetHandshakeKey(key *ecdsa.PrivateKey)
+
+	// 客户端开启握手, key 为服务端固定私钥对应的公钥, 用于校验服务端身份
+	SetHandshakePin(key *ecdsa.PublicKey)
+
+	// 开启防重放, 需要开启加密, 双端需要同时开启
+	// 每帧的明文前追加 8 字节递增的计数器, 收到重复或落后最大计数器 64 帧以上的帧时断开连接, 断开原因为 ErrReplayFrame
+	// 需要开启握手, 服务端设置 SetHandshakeKey, 客户端设置 SetHandshakePin, 否则 Listen 或 Dial 时 panic
+	// 握手后每条连接使用独立的密钥, 其他连接的帧无法在当前连接中重放
+	SetReplayProtect(enable bool)
+
+	// 开启 tls, tcp 下为 tls over tcp, websocket 下为 wss, kcp 不支持 tls
+	// certFile, keyFile 证书和私钥文件路径, 收到 SIGHUP 信号时会重新加载, 只影响之后建立的连接
+	SetTLS(certFile string, keyFile string)
+
+	// 设置 tls 配置, 可与 SetTLS 同时使用, 此时证书以 SetTLS 为准
+	SetTLSConfig(config *tls.Config)
+
+	// 设置客户端证书的 ca 文件, 设置后要求客户端提供证书并通过校验, 一般用于内部工具
+	// NewClientSocket 创建的客户端中, SetTLS 设置的是客户端证书, caFile 为校验服务端证书的 ca, 设置任意一项即开启 tls
+	SetTLSClientCA(caFile string)
+
+	// 重新加载 SetTLS 设置的证书
+	ReloadTLS() error
+
 	// 设置与客户端建立连接时的回调函数
 	SetConnStartCall(func(IConn))
 
+	// 设置 websocket 升级前的认证回调, 可读取请求头和 url 参数中的 token
+	// 返回 http.StatusOK 时允许升级, 其他状态码拒绝升级并返回该状态码, 被拒绝的请求不会建立连接
+	// attr 中设置的属性会复制到连接的属性中, 如认证得到的用户ID
+	SetUpgradeAuth(auth func(request *http.Request, attr *sync.Map) int)
+
+	// 设置第一条消息的认证回调, 对 tcp, websocket, kcp 都有效
+	// 连接建立后 timeout 内需要收到第一条消息, 消息交给 auth 校验, 不进入 worker 池和路由, timeout 为 0 时不限制
+	// auth 返回错误或超时时关闭连接, 不回调 SetConnStartCall, 认证成功后才开始处理之后的消息
+	// auth 中可以设置连接属性, 也可以通过 Request.Reply 应答, 应答在认证成功后发送
+	// 开启 SetResume 时恢复会话的连接同样需要认证
+	SetFrameAuth(timeout time.Duration, auth func(request Request) error)
+
 	// 设置与客户端断开连接时的回调函数
+	// 回调中可通过 IConn.GetStopReason 获取断开原因, 如 ErrIdleTimeout
+	// 开启 SetResume 时, 保留的会话结束后才回调
 	SetConnStopCall(func(IConn))
 
+	// 开启会话恢复, 双端需要同时开启, 建议同时开启加密, 防止令牌被窃取
+	// 建立连接时服务端下发会话令牌, 连接因网络原因断开后会话保留 grace 时间, 期间发送的消息最多缓存 bufferSize 条, 超出时丢弃最早的消息
+	// 客户端重连时携带令牌, 新连接接管会话: 沿用原连接的连接ID, 属性, 分组和加密状态, 先发送缓存的消息, 再回调 SetConnResumeCall
+	// 恢复会话时不回调 SetConnStartCall 和 SetConnStopCall, 会话超时, 主动断开或协议错误导致断开时会话结束
+	// 已写入原连接但对端未收到的消息, 以及等待应答的 Call 不会恢复
+	SetResume(grace time.Duration, bufferSize int)
+
+	// 设置恢复会话时的回调函数, 参数为接管会话的新连接, 原连接的引用之后发送的消息会转发给新连接
+	SetConnResumeCall(func(IConn))
+
+	// 设置读超时时间, 超过 timeout 未收到任何消息(包括心跳)的连接会被自动关闭, 断开原因为 ErrIdleTimeout
+	// 默认为 0, 表示不超时
+	SetReadTimeout(timeout time.Duration)
+
+	// 设置写超时时间, 单条消息超过 timeout 未写完的连接会被自动关闭, 断开原因为 ErrWriteTimeout
+	// 默认为 0, 表示不超时
+	SetWriteTimeout(timeout time.Duration)
+
+	// 设置心跳消息ID, 收到该ID的消息时由框架原样应答, 不会进入 worker 池
+	// 配合 SetReadTimeout 使用, 客户端需要在读超时时间内定时发送心跳
+	// 默认不开启
+	SetHeartbeat(msgID uint32)
+
 	// 设置消息包最大长度
 	// 默认为4096
 	SetPacketMaxSize(size int)
 
+	// 设置每条连接的发送队列长度, 必须大于 0
+	// 默认为4096
+	SetSendQueueSize(size int)
+
+	// 设置发送队列已满时的背压策略, 客户端读取过慢时发送队列会被占满
+	// policy 背压策略, 默认为 BackpressureBlock
+	// timeout 仅对 BackpressureBlock 有效, 阻塞超过 timeout 后丢弃消息, 0 表示一直阻塞
+	SetBackpressure(policy BackpressurePolicy, timeout time.Duration)
+
+	// 设置背压策略触发时的回调函数, 可用于统计或输出日志
+	SetBackpressureCall(func(IConn, BackpressurePolicy))
+
+	// 设置每条连接收到消息的限制, 用于防止单个客户端刷消息占满 worker 池
+	// 默认不限制
+	SetFloodLimit(limit FloodLimit)
+
+	// 设置超过限制时的回调函数, reason 为超过的限制, 如 ErrMsgRateLimit
+	// 可用于把刷消息的连接输出到 Error 日志
+	SetFloodCall(func(conn IConn, reason error))
+
+	// 设置允许建立连接的 ip 列表, 支持单个 ip 和 cidr, 如 "10.0.0.1", "192.168.0.0/16"
+	// 设置后只允许列表中的 ip 建立连接, 不传参数表示允许所有 ip
+	// 重复调用会覆盖之前的设置, 可在运行中调用, 只影响之后建立的连接
+	SetAllowIps(ips ...string) error
+
+	// 设置拒绝建立连接的 ip 列表, 格式同 SetAllowIps, 优先于允许列表
+	SetDenyIps(ips ...string) error
+
+	// 设置单个 ip 最大在线连接数, 默认为 0, 表示不限制
+	SetMaxConnPerIp(max int)
+
+	// 设置每秒最多接受的新连接数量, 默认为 0, 表示不限制
+	// 被拒绝的连接会在 SetConnStartCall 的回调之前关闭
+	// websocket 在升级和 SetUpgradeAuth 之前检查 ip, 被封禁或不允许时返回 403, 超过频率或连接数限制时返回 429
+	SetAcceptRate(rate int)
+
+	// 设置消息编解码方式, 如 NewJsonCodec(), NewGobCodec(), NewRawCodec()
+	// 默认使用 encoding/binary 按字节顺序读写固定长度的数据
+	// SendMsg 的 data 为 []byte 或 string 时不经过编解码, 直接发送
+	SetCodec(codec ICodec)
+
+	// 注册消息ID对应的消息类型, msg 为该类型的值或指针, 如 RegMsg(1001, &LoginReq{})
+	// 收到该ID的消息时会解码为 msg 的类型, Request.Data 为指向该类型的指针, 如 *LoginReq
+	// 未注册的消息ID, Request.Data 为原始的 []byte
+	RegMsg(msgID uint32, msg interface{})
+
+	// 注册应答消息ID对应的消息类型, 用法同 RegMsg
+	// 应答的消息ID与请求相同, Call 收到应答时按该类型解码, 未注册时为原始的 []byte
+	RegReply(msgID uint32, msg interface{})
+
+	// 开启消息序列号, 开启后消息头在 packer 的消息头之后追加 4 字节的序列号
+	// 用于 IConn.Call 和 Request.Reply 匹配请求和应答, 序列号最高位为 1 表示应答
+	// 双端需要同时开启, 默认不开启
+	SetSeq(enable bool)
+
+	// 设置消息头格式, 用于对接自定义协议的客户端, 如 SetPacker(NewPacker(2, 2, 1))
+	// 默认 tcp 为 len uint32 + id uint32, websocket 为 id uint32, tcp 的消息头必须包含 len 字段, 否则 Listen 或 Dial 时 panic
+	SetPacker(packer IPacker)
+
+	// 设置消息压缩, 消息体长度不小于 threshold 时压缩, 并在消息头的 flags 中标记 FlagCompressed
+	// 开启后默认消息头格式追加 1 字节的 flags 字段, 使用 SetPacker 自定义格式时需要包含 flags 字段, 否则 Listen 或 Dial 时 panic
+	// 压缩在加密之前, 解压在解密之后, 解压后的长度最多为 SetPacketMaxSize 的 64 倍
+	// 双端需要设置相同的压缩方式, 默认不压缩
+	SetCompress(compress Compression, threshold int)
+
 	// 设置字节顺序
 	// 字节数据可以存放在低地址处, 也可以存放在高地址处, 若双端出现字节数据高低位相反, 就要考虑到字节顺序问题
 	// 默认为big endian
@@ -62,14 +224,97 @@ type ISocket interface {
 	// 初始化工作池
 	// poolSize 工作池的 worker 数量
 	// taskSize 每个 worker 最大缓存任务数量
-	// request 设置客户端发起请求时的回调函数
-	// 工作池最大任务缓存数量 =  poolSize * taskSize
+	// request 设置客户端发起请求时的回调函数, 可为 nil
+	//		(使用 Route 注册路由后, 只有未命中路由的请求才会交给 request 处理, 等同于 SetNotFound)
+	// 工作池最大任务缓存数量 =  poolSize * taskSize, worker 缓存已满时暂停读取绑定该 worker 的连接
 	InitWorkerPool(poolSize int, taskSize int, request func(Request))
 
+	// 设置分配 worker 前的回调函数, 可修改 request.Key 决定处理该请求的 worker
+	// 默认 Key 为连接ID, 同一个 Key 的请求在同一个 worker 中按顺序处理
+	// 如按房间ID分配, 同一个房间内所有玩家的请求依次处理, 不需要额外加锁
+	// 回调在连接的 reader 中执行, 不要在回调中处理耗时逻辑
+	// 回调 panic 时与处理函数 panic 一样记录并回调 SetPanicCall, 之后断开连接, 断开原因为 ErrDispatchPanic
+	SetDispatchCall(func(request *Request))
+
+	// 调整 worker 池的 worker 数量, 可在运行中调用
+	// 调整前会暂停分配请求并等待已分配的请求处理完成, 同一个 Key 的请求依然有序
+	// 不能在 worker 池的处理函数中调用, 等待期间处理函数中可以调用 WorkerStats
+	ResizeWorkerPool(size int)
+
+	// 获取每个 worker 的状态
+	WorkerStats() []WorkerStat
+
+	// 注册消息路由, msgID 的请求交给 handler 处理
+	// 同一个 msgID 重复注册会 panic
+	// 路由在 worker 池中执行, 单条连接的消息处理依然是有序的
+	Route(msgID uint32, handler func(Request))
+
+	// 注册消息ID区间路由, [begin, end] 闭区间内的请求交给 handler 处理
+	// 区间之间不允许重叠, 单个 msgID 的路由优先于区间路由
+	RouteRange(begin uint32, end uint32, handler func(Request))
+
+	// 设置未命中任何路由时的处理函数
+	// 默认输出 debug 日志
+	SetNotFound(handler func(Request))
+
+	// 获取已注册的路由列表, 按消息ID升序排列
+	Routes() []RouteInfo
+
+	// 添加中间件, 包装 worker 池中每个请求的处理, 包括路由和未命中路由的处理函数
+	// 先添加的中间件在外层, 不调用 next 时请求不再继续处理, 需要在 Listen 或 Dial 之前添加
+	// 内置中间件: TimingMiddleware, DebugMiddleware, AuthMiddleware
+	Use(middleware func(next Handler) Handler)
+
+	// 设置处理函数 panic 时的回调函数, 可用于踢掉出错的连接
+	// panic 只影响当前请求, 框架会恢复并通过 logs.Stack 输出堆栈, err 为 recover 的返回值
+	SetPanicCall(func(request Request, err interface{}))
+
+	// 获取每个消息ID处理函数 panic 的次数
+	PanicCounts() map[uint32]int64
+
 	// 开启端口监听, host ip地址, port 端口 param 扩展参数
 	// tcp socket 下, param 表示 tcp 版本("tcp", "tcp4", "tcp6"), 为空表示"tcp"
 	// websocket 下, param 表示 pattern 模式, 如 "/ws"
+	// kcp socket 下, param 表示 udp 版本("udp", "udp4", "udp6"), 为空表示"udp"
 	Listen(host string, port int, param string)
+
+	// 根据连接ID获取在线连接
+	GetConn(id int) (IConn, bool)
+
+	// 遍历所有在线连接, f 返回 false 时停止遍历
+	Range(f func(IConn) bool)
+
+	// 获取在线连接数量
+	Count() int
+
+	// 踢掉指定连接, 会触发 SetConnStopCall 设置的回调
+	Kick(id int)
+
+	// 给所有在线连接发送消息
+	// id 消息ID, data 消息内容
+	BroadcastMsg(id int, data interface{})
+
+	// 把连接加入分组, 分组不存在时自动创建, 可用于公会聊天, 战斗房间, 世界频道等
+	// 一条连接可以加入多个分组, 连接断开时自动离开所有分组
+	Join(group string, conn IConn)
+
+	// 把连接移出分组, 分组没有成员时自动删除
+	Leave(group string, conn IConn)
+
+	// 获取分组的所有成员, 返回的是成员快照
+	Members(group string) []IConn
+
+	// 给分组的所有成员发送消息, except 为不需要发送的连接, 如消息的发送者
+	Broadcast(group string, msgID int, data interface{}, except ...IConn)
+
+	// 优雅关闭, 依次执行:
+	//	关闭监听, 不再接受新连接
+	//	停止读取所有连接的消息
+	//	处理完 worker 池中已缓存的任务
+	//	写完每条连接缓存中的消息
+	//	断开所有连接, 触发 SetConnStopCall 设置的回调, 断开原因为 ErrServerShutdown
+	// ctx 超时后会跳过剩余步骤直接断开所有连接, 并返回 ctx.Err()
+	Shutdown(ctx context.Context) error
 }
 
 type IRpc interface {
@@ -92,6 +337,56 @@ type IRpc interface {
 	CloseDone()
 }
 
+// 消息头
+type PacketHead struct {
+	// 消息体长度, websocket 下由消息边界决定, 可以没有该字段
+	Len uint32
+	// 消息ID
+	ID uint32
+	// 标记位
+	Flags uint8
+	// 序列号, 开启 SetSeq 后有效, 0 表示不需要应答
+	Seq uint32
+}
+
+// 消息头的标记位
+const (
+	// 消息体已压缩
+	FlagCompressed uint8 = 1 << iota
+)
+
+// 消息压缩方式
+type Compression int
+
+const (
+	// 不压缩
+	CompressNone Compression = iota
+	// compress/flate
+	CompressFlate
+	// compress/zlib
+	CompressZlib
+	// compress/gzip
+	CompressGzip
+)
+
+// 消息头格式
+type IPacker interface {
+	// 消息头长度
+	HeadLen() int
+	// 把消息头和消息体打包为一帧, 打包时 head.Len 以 body 的长度为准
+	Pack(head PacketHead, body []byte, order binary.ByteOrder) ([]byte, error)
+	// 解析消息头, data 长度不小于 HeadLen()
+	UnPack(data []byte, order binary.ByteOrder) (PacketHead, error)
+}
+
+// 消息编解码
+type ICodec interface {
+	// 把消息内容编码为字节
+	Marshal(data interface{}) ([]byte, error)
+	// 把字节解码到 v, v 为指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
 type IConn interface {
 	// 启动连接
 	Start()
@@ -101,16 +396,111 @@ type IConn interface {
 	GetConnID() int
 	// 获取连接地址
 	GetRemoteAddr() net.Addr
+	// 获取断开原因, 连接未断开时为 nil
+	GetStopReason() error
 	// 发送消息
 	WriteMsg([]byte)
-	// 发送消息
+	// 发送消息, data 按 socket 设置的编解码方式编码
 	SendMsg(id int, data interface{})
+	// 发送请求并等待对端通过 Request.Reply 应答, 需要开启 SetSeq
+	// 返回应答内容, 超过 timeout 未收到应答时返回 ErrCallTimeout, timeout 为 0 表示不超时
+	Call(id int, data interface{}, timeout time.Duration) (interface{}, error)
+	// 发送请求, 不阻塞, 应答结果写入返回的通道
+	CallAsync(id int, data interface{}, timeout time.Duration) <-chan CallResult
 	// 获取属性
 	QueryAttr() *sync.Map
 }
 
+// Call 的应答结果
+type CallResult struct {
+	// 应答内容, 按 RegReply 注册的类型解码
+	Data interface{}
+	// 超时为 ErrCallTimeout, 连接断开时为断开原因
+	Err error
+}
+
+// 发送队列已满时的背压策略
+type BackpressurePolicy int
+
+const (
+	// 阻塞发送方, 直到队列有空位或超时, 超时后丢弃当前消息
+	BackpressureBlock BackpressurePolicy = iota
+	// 丢弃当前消息
+	BackpressureDropNewest
+	// 丢弃队列中最早的消息
+	BackpressureDropOldest
+	// 断开连接, 断开原因为 ErrSlowConsumer
+	BackpressureDisconnect
+)
+
+// 连接收到消息的限制, 字段为 0 表示不限制该项
+type FloodLimit struct {
+	// 每秒最多收到的消息数量
+	MsgPerSecond int
+	// 每秒最多收到的字节数
+	BytesPerSecond int
+	// 最多等待 worker 池处理的任务数量
+	MaxPending int
+	// 超过限制时的处理方式
+	Action FloodAction
+	// FloodBan 的封禁时长
+	BanTime time.Duration
+}
+
+// 超过 FloodLimit 限制时的处理方式
+type FloodAction int
+
+const (
+	// 丢弃超过限制的消息
+	FloodDrop FloodAction = iota
+	// 暂停读取该连接的消息, 直到低于限制
+	FloodDelay
+	// 断开连接, 断开原因为超过的限制, 如 ErrMsgRateLimit
+	FloodKick
+	// 断开连接, 并在 BanTime 内拒绝该 ip 的新连接
+	FloodBan
+)
+
+// 客户端连接, 通过 DialTcp, DialWebsocket, DialKcp 创建
+// 收到的消息按客户端配置的路由分发, Request.Conn 为当前的底层连接
+type IClient interface {
+	IConn
+
+	// 设置断线自动重连, 需在连接断开前设置
+	// minDelay 首次重连的等待时间, 每次失败后翻倍, 最大为 maxDelay
+	// 默认不重连, 重连成功后为新的连接, 连接ID和属性都会变化, 开启 SetResume 且恢复会话成功时保持不变
+	SetReconnect(minDelay time.Duration, maxDelay time.Duration)
+
+	// 当前是否处于连接状态
+	IsConnected() bool
+}
+
+// 请求处理函数, 见 ISocket.Use
+type Handler func(request Request)
+
 type Request struct {
 	Conn IConn
 	ID   uint32
+	// 序列号, 对端通过 Call 发送时不为 0, 需要通过 Reply 应答
+	Seq uint32
+	// 分配 worker 的依据, 默认为连接ID, 可通过 SetDispatchCall 修改
+	Key  int
 	Data interface{}
 }
+
+// worker 状态
+type WorkerStat struct {
+	// 缓存中等待处理的任务数量
+	Queue int `json:"queue"`
+	// 最大缓存任务数量
+	Cap int `json:"cap"`
+	// 已处理的任务数量
+	Handled int64 `json:"handled"`
+}
+
+// 路由信息, 单个消息ID的路由 Begin == End
+type RouteInfo struct {
+	Begin   uint32
+	End     uint32
+	Handler string
+}
@@ -0,0 +1,178 @@
+package _net
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"time"
+)
+
+// 握手超时时间
+const handshakeTimeout = 10 * time.Second
+
+// 握手签名内容的前缀
+var handshakeLabel = []byte("gofly handshake v1")
+
+func (_this *socket) SetHandshakeKey(key *ecdsa.PrivateKey) {
+	if key.Curve != elliptic.P256() {
+		logs.Panic("handshake key curve must be P-256")
+	}
+
+	_this.handshakeKey = key
+}
+
+func (_this *socket) SetHandshakePin(key *ecdsa.PublicKey) {
+	if key.Curve != elliptic.P256() {
+		logs.Panic("handshake pin curve must be P-256")
+	}
+
+	_this.handshakePin = key
+}
+
+// 是否开启握手, 服务端设置 SetHandshakeKey, 客户端设置 SetHandshakePin
+func (_this *socket) isHandshake() bool {
+	if _this.client {
+		return _this.handshakePin != nil
+	}
+
+	return _this.handshakeKey != nil
+}
+
+// 连接的加密状态
+type connCipher struct {
+	// 握手协商的会话密钥, 为 nil 时使用 socket 设置的密钥
+	key []byte
+	// 防重放的发送计数器
+	sendCounter uint64
+	// 防重放收到的最大计数器, recvWindow 的第 i 位表示是否收到过 recvMax-i
+	recvMax    uint64
+	recvWindow uint64
+}
+
+// 读写握手消息
+type handshakeConn interface {
+	readHandshake() ([]byte, error)
+	writeHandshake([]byte) error
+	// 设置握手的超时时间, 为零值时取消
+	setHandshakeDeadline(t time.Time)
+	// 连接的加密状态
+	getCipher() *connCipher
+}
+
+// 握手, 成功后设置连接的会话密钥, 未开启握手或已完成握手时直接返回
+// 客户端在建立连接时握手, 服务端在 IConn.Start 中握手
+func (_this *socket) handshake(conn handshakeConn) error {
+	cipher := conn.getCipher()
+	if !_this.isHandshake() || cipher.key != nil {
+		return nil
+	}
+
+	conn.setHandshakeDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.setHandshakeDeadline(time.Time{})
+
+	var (
+		key []byte
+		err error
+	)
+	if _this.client {
+		key, err = _this.clientHandshake(conn)
+	} else {
+		key, err = _this.serverHandshake(conn)
+	}
+
+	if err != nil {
+		return fmt.Errorf("handshake err: %v", err)
+	}
+
+	cipher.key = key
+	return nil
+}
+
+// 客户端握手, 发送临时公钥, 收到服务端的临时公钥和签名后用固定的服务端公钥校验签名, 再用 ecdh 协商出会话密钥
+func (_this *socket) clientHandshake(conn handshakeConn) ([]byte, error) {
+	curve := elliptic.P256()
+	priv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	clientPub := elliptic.Marshal(curve, x, y)
+	if err = conn.writeHandshake(clientPub); err != nil {
+		return nil, err
+	}
+
+	msg, err := conn.readHandshake()
+	if err != nil {
+		return nil, err
+	}
+
+	pubLen := len(clientPub)
+	if len(msg) <= pubLen {
+		return nil, errors.New("server hello short")
+	}
+
+	serverPub, sign := msg[:pubLen], msg[pubLen:]
+	if !ecdsa.VerifyASN1(_this.handshakePin, handshakeDigest(clientPub, serverPub), sign) {
+		return nil, errors.New("server key signature invalid")
+	}
+
+	return sessionKey(priv, serverPub, clientPub, serverPub)
+}
+
+// 服务端握手, 收到客户端的临时公钥后用 ecdh 协商出会话密钥, 发送服务端的临时公钥, 以及用固定私钥对双方临时公钥的签名
+func (_this *socket) serverHandshake(conn handshakeConn) ([]byte, error) {
+	clientPub, err := conn.readHandshake()
+	if err != nil {
+		return nil, err
+	}
+
+	curve := elliptic.P256()
+	priv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serverPub := elliptic.Marshal(curve, x, y)
+	key, err := sessionKey(priv, clientPub, clientPub, serverPub)
+	if err != nil {
+		return nil, err
+	}
+
+	sign, err := ecdsa.SignASN1(rand.Reader, _this.handshakeKey, handshakeDigest(clientPub, serverPub))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.writeHandshake(append(serverPub, sign...)); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// 签名内容
+func handshakeDigest(clientPub, serverPub []byte) []byte {
+	digest := sha256.Sum256(bytes.Join([][]byte{handshakeLabel, clientPub, serverPub}, nil))
+	return digest[:]
+}
+
+// 用本端的临时私钥和对端的临时公钥协商会话密钥, 密钥为 32 字节, 用于 aes-256-gcm
+func sessionKey(priv []byte, peerPub []byte, clientPub, serverPub []byte) ([]byte, error) {
+	curve := elliptic.P256()
+
+	// 不在曲线上的公钥返回 nil
+	x, y := elliptic.Unmarshal(curve, peerPub)
+	if x == nil {
+		return nil, errors.New("peer key invalid")
+	}
+
+	sx, _ := curve.ScalarMult(x, y, priv)
+
+	key := sha256.Sum256(bytes.Join([][]byte{sx.FillBytes(make([]byte, 32)), clientPub, serverPub}, nil))
+	return key[:], nil
+}
@@ -0,0 +1,213 @@
+package _net
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 超过频率或连接数限制
+var errIpLimit = errors.New("ip limit")
+
+func (_this *socket) SetAllowIps(ips ...string) error {
+	nets, err := parseIpNets(ips)
+	if err != nil {
+		return err
+	}
+
+	_this.ipFilter.mu.Lock()
+	defer _this.ipFilter.mu.Unlock()
+
+	_this.ipFilter.allow = nets
+	return nil
+}
+
+func (_this *socket) SetDenyIps(ips ...string) error {
+	nets, err := parseIpNets(ips)
+	if err != nil {
+		return err
+	}
+
+	_this.ipFilter.mu.Lock()
+	defer _this.ipFilter.mu.Unlock()
+
+	_this.ipFilter.deny = nets
+	return nil
+}
+
+func (_this *socket) SetMaxConnPerIp(max int) {
+	_this.ipFilter.mu.Lock()
+	defer _this.ipFilter.mu.Unlock()
+
+	_this.ipFilter.maxPerIp = max
+}
+
+func (_this *socket) SetAcceptRate(rate int) {
+	_this.ipFilter.mu.Lock()
+	defer _this.ipFilter.mu.Unlock()
+
+	if rate > 0 {
+		_this.ipFilter.accept = newTokenBucket(rate)
+	} else {
+		_this.ipFilter.accept = nil
+	}
+}
+
+// 检查是否接受新连接, 接受时计入 ip 的连接数, 连接断开时需要调用 release
+func (_this *socket) admit(conn IConn) bool {
+	if _this.isClosing() {
+		return false
+	}
+
+	ip := addrIP(conn.GetRemoteAddr())
+
+	if _this.bans.banned(ip) {
+		logs.Debug("conn rejected, ip banned:", ip)
+		return false
+	}
+
+	if err := _this.ipFilter.admit(ip); err != nil {
+		logs.Debug("conn rejected:", err)
+		return false
+	}
+
+	return true
+}
+
+// websocket 已在升级前计入 ip 的连接数, 只检查 socket 是否关闭中, 拒绝时减少计数
+func (_this *socket) admitUpgraded(conn IConn) bool {
+	if _this.isClosing() {
+		_this.ipFilter.release(addrIP(conn.GetRemoteAddr()))
+		return false
+	}
+
+	return true
+}
+
+// websocket 升级前检查 ip, 返回 ip 和是否允许升级, 拒绝时输出 403, 超过频率或连接数限制时输出 429
+// 允许时计入 ip 的连接数, 之后未建立连接时需要调用 release
+func (_this *socket) admitUpgrade(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	ip := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	if _this.bans.banned(ip) {
+		logs.Debug("websocket upgrade rejected, ip banned:", ip)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return ip, false
+	}
+
+	if err := _this.ipFilter.admit(ip); err != nil {
+		status := http.StatusForbidden
+		if errors.Is(err, errIpLimit) {
+			status = http.StatusTooManyRequests
+		}
+
+		logs.Debug("websocket upgrade rejected:", err)
+		http.Error(writer, http.StatusText(status), status)
+		return ip, false
+	}
+
+	return ip, true
+}
+
+// ip 过滤, 可在运行中修改
+type ipFilter struct {
+	mu       sync.Mutex
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	maxPerIp int
+	accept   *tokenBucket
+	// 每个 ip 的在线连接数
+	conns map[string]int
+}
+
+func newIpFilter() *ipFilter {
+	return &ipFilter{conns: make(map[string]int)}
+}
+
+// 检查 ip 是否可以建立连接, 可以时计入 ip 的连接数
+func (_this *ipFilter) admit(ip string) error {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	addr := net.ParseIP(ip)
+
+	if containsIp(_this.deny, addr) {
+		return fmt.Errorf("ip denied: %s", ip)
+	}
+
+	if len(_this.allow) > 0 && !containsIp(_this.allow, addr) {
+		return fmt.Errorf("ip not allowed: %s", ip)
+	}
+
+	if _this.accept != nil && _this.accept.take(1, false) > 0 {
+		return fmt.Errorf("%w, accept rate, ip: %s", errIpLimit, ip)
+	}
+
+	if _this.maxPerIp > 0 && _this.conns[ip] >= _this.maxPerIp {
+		return fmt.Errorf("%w, conn per ip: %d, ip: %s", errIpLimit, _this.maxPerIp, ip)
+	}
+
+	_this.conns[ip]++
+	return nil
+}
+
+// 连接断开, 减少 ip 的连接数
+func (_this *ipFilter) release(ip string) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.conns[ip]--; _this.conns[ip] <= 0 {
+		delete(_this.conns, ip)
+	}
+}
+
+// 解析 ip 或 cidr 列表, 单个 ip 按 /32 或 /128 处理
+func parseIpNets(ips []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ips))
+
+	for _, v := range ips {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip: %s", v)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %s", v)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// ip 是否在列表中
+func containsIp(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, v := range nets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
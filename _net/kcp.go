@@ -0,0 +1,743 @@
+package _net
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 基于 udp 的可靠有序传输, 参考 kcp 的 arq 实现
+// 每个 udp 包只包含一个分片:
+//
+//	conv uint32 + cmd uint8 + frg uint8 + wnd uint16 + sn uint32 + una uint32 + data
+//
+// conv 会话ID, 由客户端生成
+// frg 消息剩余分片数, 为 0 表示消息的最后一个分片
+// wnd 接收窗口剩余大小
+// sn 分片序号
+// una 期望收到的下一个分片序号, 之前的分片都已收到
+const (
+	kcpCmdPush  = 1 // 数据
+	kcpCmdAck   = 2 // 确认
+	kcpCmdClose = 3 // 关闭
+
+	kcpHeadLen    = 16
+	kcpMtu        = 1400
+	kcpMss        = kcpMtu - kcpHeadLen
+	kcpWnd        = 256                    // 收发窗口大小(分片数)
+	kcpSndQueue   = kcpWnd * 4             // 发送队列最大分片数, 超过后写入阻塞
+	kcpMaxFrg     = 255                    // 单条消息最大分片数
+	kcpInterval   = 10 * time.Millisecond  // 刷新间隔
+	kcpRtoMin     = 30 * time.Millisecond  // 最小重传超时
+	kcpRtoDef     = 200 * time.Millisecond // 默认重传超时
+	kcpRtoMax     = 5 * time.Second        // 最大重传超时
+	kcpDeadLink   = 20                     // 单个分片最大重传次数, 超过后认为连接已断开
+	kcpFastResend = 2                      // 被跳过确认的次数达到后立即重传
+	kcpLinger     = 3 * time.Second        // 关闭时等待已发送数据被确认的最长时间
+	kcpConvIdle   = 10 * time.Second       // 相同地址收到其他 conv 的数据时, 旧会话空闲超过该时间才被替换
+)
+
+var (
+	errKcpClosed   = errors.New("kcp session closed")
+	errKcpDeadLink = kcpDeadLinkError{}
+	errKcpMsgLong  = errors.New("kcp msg data long")
+)
+
+// 组装中的消息最大长度, 超过时认为对端异常
+const kcpMaxMsgSize = kcpMaxFrg * kcpMss
+
+// 读写超时错误, 实现 net.Error
+type kcpTimeoutError struct{}
+
+func (kcpTimeoutError) Error() string   { return "kcp i/o timeout" }
+func (kcpTimeoutError) Timeout() bool   { return true }
+func (kcpTimeoutError) Temporary() bool { return true }
+
+// 分片超过重传次数, 实现 net.Error, 与 tcp 连接的网络错误一样可以恢复会话
+type kcpDeadLinkError struct{}
+
+func (kcpDeadLinkError) Error() string   { return "kcp dead link" }
+func (kcpDeadLinkError) Timeout() bool   { return false }
+func (kcpDeadLinkError) Temporary() bool { return false }
+
+type kcpSegment struct {
+	cmd      uint8
+	frg      uint8
+	sn       uint32
+	data     []byte
+	xmit     int           // 发送次数
+	rto      time.Duration // 重传超时
+	sentAt   time.Time     // 最近一次发送时间
+	resendAt time.Time     // 重传时间
+	fastAck  int           // 被跳过确认的次数
+}
+
+// 序号比较, 兼容回绕
+func snDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
+
+type kcpSession struct {
+	mu     sync.Mutex
+	conv   uint32
+	remote *net.UDPAddr
+	output func(data []byte, addr *net.UDPAddr) error
+
+	sndNxt   uint32
+	sndUna   uint32
+	rcvNxt   uint32
+	rmtWnd   uint16
+	sndQueue []*kcpSegment
+	sndBuf   []*kcpSegment
+	rcvBuf   map[uint32]*kcpSegment
+	rcvQueue [][]byte
+	frags    []byte
+	ackList  []uint32
+
+	srtt   time.Duration
+	rttVar time.Duration
+	rto    time.Duration
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readNotify    chan bool
+	writeNotify   chan bool
+
+	// 调用 Close 后不再读写, 等待已发送的数据被确认
+	closed    chan bool
+	closeOnce sync.Once
+	// 关闭原因, closed 关闭后读写返回该错误, 对端关闭时为 io.EOF
+	closeErr error
+	// 会话彻底销毁
+	die     chan bool
+	dieOnce sync.Once
+	onDie   func(*kcpSession)
+	// 最近收到本会话数据的时间, 只在 kcpListener 持有锁时读写
+	active time.Time
+}
+
+func newKcpSession(conv uint32, remote *net.UDPAddr, output func([]byte, *net.UDPAddr) error) *kcpSession {
+	s := &kcpSession{
+		conv:        conv,
+		remote:      remote,
+		output:      output,
+		rmtWnd:      kcpWnd,
+		rcvBuf:      make(map[uint32]*kcpSegment),
+		rto:         kcpRtoDef,
+		readNotify:  make(chan bool, 1),
+		writeNotify: make(chan bool, 1),
+		closed:      make(chan bool),
+		die:         make(chan bool),
+	}
+
+	go s.update()
+	return s
+}
+
+func (_this *kcpSession) RemoteAddr() net.Addr {
+	return _this.remote
+}
+
+func (_this *kcpSession) SetReadDeadline(t time.Time) error {
+	_this.mu.Lock()
+	_this.readDeadline = t
+	_this.mu.Unlock()
+
+	notify(_this.readNotify)
+	return nil
+}
+
+func (_this *kcpSession) SetWriteDeadline(t time.Time) error {
+	_this.mu.Lock()
+	_this.writeDeadline = t
+	_this.mu.Unlock()
+
+	notify(_this.writeNotify)
+	return nil
+}
+
+// 读取一条完整的消息
+func (_this *kcpSession) ReadMessage() ([]byte, error) {
+	for {
+		_this.mu.Lock()
+		if len(_this.rcvQueue) > 0 {
+			msg := _this.rcvQueue[0]
+			_this.rcvQueue = _this.rcvQueue[1:]
+			_this.mu.Unlock()
+			return msg, nil
+		}
+		deadline := _this.readDeadline
+		_this.mu.Unlock()
+
+		if err := _this.wait(_this.readNotify, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 写入一条完整的消息, 按 mss 拆分为多个分片
+func (_this *kcpSession) WriteMessage(msg []byte) error {
+	count := (len(msg) + kcpMss - 1) / kcpMss
+	if count == 0 {
+		count = 1
+	}
+
+	if count > kcpMaxFrg {
+		return errKcpMsgLong
+	}
+
+	for {
+		_this.mu.Lock()
+		select {
+		case <-_this.closed:
+			_this.mu.Unlock()
+			return _this.closeErr
+		default:
+		}
+
+		if len(_this.sndQueue) < kcpSndQueue {
+			for i := 0; i < count; i++ {
+				size := len(msg)
+				if size > kcpMss {
+					size = kcpMss
+				}
+
+				data := make([]byte, size)
+				copy(data, msg[:size])
+				msg = msg[size:]
+
+				_this.sndQueue = append(_this.sndQueue, &kcpSegment{cmd: kcpCmdPush, frg: uint8(count - i - 1), data: data})
+			}
+			_this.mu.Unlock()
+			return nil
+		}
+		deadline := _this.writeDeadline
+		_this.mu.Unlock()
+
+		if err := _this.wait(_this.writeNotify, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// 等待通知, 会话关闭或超时返回错误
+func (_this *kcpSession) wait(c chan bool, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return kcpTimeoutError{}
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-c:
+		return nil
+	case <-_this.closed:
+		return _this.closeErr
+	case <-timeout:
+		return kcpTimeoutError{}
+	}
+}
+
+// 关闭会话, 不再读写, 已发送的数据被确认或超过 kcpLinger 后销毁会话
+func (_this *kcpSession) Close() error {
+	_this.closeOnce.Do(func() {
+		_this.closeErr = errKcpClosed
+		close(_this.closed)
+
+		go func() {
+			deadline := time.Now().Add(kcpLinger)
+			for time.Now().Before(deadline) {
+				_this.mu.Lock()
+				pending := len(_this.sndQueue) + len(_this.sndBuf)
+				_this.mu.Unlock()
+
+				if pending == 0 {
+					break
+				}
+
+				select {
+				case <-_this.die:
+					return
+				case <-time.After(kcpInterval):
+				}
+			}
+
+			_this.mu.Lock()
+			_this.send(&kcpSegment{cmd: kcpCmdClose})
+			_this.mu.Unlock()
+			_this.destroy(errKcpClosed)
+		}()
+	})
+
+	return nil
+}
+
+// 销毁会话, err 为未调用 Close 时读写返回的错误
+func (_this *kcpSession) destroy(err error) {
+	_this.closeOnce.Do(func() {
+		_this.closeErr = err
+		close(_this.closed)
+	})
+
+	_this.dieOnce.Do(func() {
+		close(_this.die)
+		if _this.onDie != nil {
+			_this.onDie(_this)
+		}
+	})
+}
+
+// 处理收到的 udp 包
+func (_this *kcpSession) input(data []byte) {
+	if len(data) < kcpHeadLen {
+		return
+	}
+
+	var (
+		cmd = data[4]
+		frg = data[5]
+		wnd = binary.LittleEndian.Uint16(data[6:])
+		sn  = binary.LittleEndian.Uint32(data[8:])
+		una = binary.LittleEndian.Uint32(data[12:])
+	)
+
+	if cmd == kcpCmdClose {
+		_this.destroy(io.EOF)
+		return
+	}
+
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.rmtWnd = wnd
+	_this.parseUna(una)
+
+	switch cmd {
+	case kcpCmdAck:
+		_this.parseAck(sn)
+	case kcpCmdPush:
+		// 分片数超过限制, 对端异常, 销毁会话
+		if frg >= kcpMaxFrg {
+			_this.destroy(errKcpMsgLong)
+			return
+		}
+
+		// 重复的分片, 之前的确认可能丢失, 需要再次确认
+		if snDiff(sn, _this.rcvNxt) < 0 {
+			_this.ackList = append(_this.ackList, sn)
+			return
+		}
+
+		// 超出接收窗口或接收队列已满时不确认, 等待对端重传
+		if snDiff(sn, _this.rcvNxt+kcpWnd) >= 0 || len(_this.rcvQueue) >= kcpWnd {
+			return
+		}
+
+		_this.ackList = append(_this.ackList, sn)
+		if _, ok := _this.rcvBuf[sn]; !ok {
+			body := make([]byte, len(data)-kcpHeadLen)
+			copy(body, data[kcpHeadLen:])
+			_this.rcvBuf[sn] = &kcpSegment{frg: frg, sn: sn, data: body}
+		}
+
+		if err := _this.moveRcv(); err != nil {
+			_this.destroy(err)
+		}
+	}
+}
+
+// una 之前的分片都已被对端收到
+func (_this *kcpSession) parseUna(una uint32) {
+	idx := 0
+	for ; idx < len(_this.sndBuf); idx++ {
+		if snDiff(_this.sndBuf[idx].sn, una) >= 0 {
+			break
+		}
+	}
+
+	if idx > 0 {
+		_this.sndBuf = _this.sndBuf[idx:]
+		notify(_this.writeNotify)
+	}
+	_this.updateUna()
+}
+
+// 确认单个分片, 并统计被跳过确认的分片
+func (_this *kcpSession) parseAck(sn uint32) {
+	for i, seg := range _this.sndBuf {
+		if seg.sn == sn {
+			if seg.xmit == 1 {
+				_this.updateRtt(time.Since(seg.sentAt))
+			}
+			_this.sndBuf = append(_this.sndBuf[:i], _this.sndBuf[i+1:]...)
+			notify(_this.writeNotify)
+			break
+		}
+
+		if snDiff(seg.sn, sn) < 0 {
+			seg.fastAck++
+		}
+	}
+	_this.updateUna()
+}
+
+func (_this *kcpSession) updateUna() {
+	if len(_this.sndBuf) > 0 {
+		_this.sndUna = _this.sndBuf[0].sn
+	} else {
+		_this.sndUna = _this.sndNxt
+	}
+}
+
+// 按 rfc6298 计算重传超时
+func (_this *kcpSession) updateRtt(rtt time.Duration) {
+	if _this.srtt == 0 {
+		_this.srtt = rtt
+		_this.rttVar = rtt / 2
+	} else {
+		delta := _this.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		_this.rttVar = (3*_this.rttVar + delta) / 4
+		_this.srtt = (7*_this.srtt + rtt) / 8
+	}
+
+	rto := 4 * _this.rttVar
+	if rto < kcpInterval {
+		rto = kcpInterval
+	}
+	_this.rto = clampDuration(_this.srtt+rto, kcpRtoMin, kcpRtoMax)
+}
+
+// 把连续的分片组装为消息, 放入接收队列, 消息超过 kcpMaxMsgSize 时返回错误
+func (_this *kcpSession) moveRcv() error {
+	for {
+		seg, ok := _this.rcvBuf[_this.rcvNxt]
+		if !ok {
+			break
+		}
+
+		delete(_this.rcvBuf, _this.rcvNxt)
+		_this.rcvNxt++
+
+		if len(_this.frags)+len(seg.data) > kcpMaxMsgSize {
+			return errKcpMsgLong
+		}
+		_this.frags = append(_this.frags, seg.data...)
+
+		if seg.frg == 0 {
+			_this.rcvQueue = append(_this.rcvQueue, _this.frags)
+			_this.frags = nil
+			notify(_this.readNotify)
+		}
+	}
+
+	return nil
+}
+
+// 定时发送确认, 新数据和重传数据
+func (_this *kcpSession) update() {
+	ticker := time.NewTicker(kcpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-_this.die:
+			return
+		case <-ticker.C:
+			if err := _this.flush(); err != nil {
+				_this.destroy(err)
+				return
+			}
+		}
+	}
+}
+
+func (_this *kcpSession) flush() error {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	for _, sn := range _this.ackList {
+		_this.send(&kcpSegment{cmd: kcpCmdAck, sn: sn})
+	}
+	_this.ackList = _this.ackList[:0]
+
+	// 发送窗口取本地窗口和对端接收窗口的较小值, 对端窗口为 0 时保留一个分片用于探测
+	cwnd := int32(kcpWnd)
+	if int32(_this.rmtWnd) < cwnd {
+		cwnd = int32(_this.rmtWnd)
+	}
+	if cwnd == 0 {
+		cwnd = 1
+	}
+
+	for len(_this.sndQueue) > 0 && snDiff(_this.sndNxt, _this.sndUna) < cwnd {
+		seg := _this.sndQueue[0]
+		_this.sndQueue = _this.sndQueue[1:]
+		seg.sn = _this.sndNxt
+		_this.sndNxt++
+		_this.sndBuf = append(_this.sndBuf, seg)
+		notify(_this.writeNotify)
+	}
+
+	now := time.Now()
+	for _, seg := range _this.sndBuf {
+		switch {
+		case seg.xmit == 0:
+			seg.rto = _this.rto
+		case !now.Before(seg.resendAt):
+			seg.rto = clampDuration(seg.rto*2, kcpRtoMin, kcpRtoMax)
+		case seg.fastAck >= kcpFastResend:
+			seg.fastAck = 0
+		default:
+			continue
+		}
+
+		if seg.xmit >= kcpDeadLink {
+			return errKcpDeadLink
+		}
+
+		seg.xmit++
+		seg.sentAt = now
+		seg.resendAt = now.Add(seg.rto)
+		_this.send(seg)
+	}
+
+	return nil
+}
+
+// 发送一个分片
+func (_this *kcpSession) send(seg *kcpSegment) {
+	wnd := kcpWnd - len(_this.rcvQueue)
+	if wnd < 0 {
+		wnd = 0
+	}
+
+	buff := make([]byte, kcpHeadLen+len(seg.data))
+	binary.LittleEndian.PutUint32(buff, _this.conv)
+	buff[4] = seg.cmd
+	buff[5] = seg.frg
+	binary.LittleEndian.PutUint16(buff[6:], uint16(wnd))
+	binary.LittleEndian.PutUint32(buff[8:], seg.sn)
+	binary.LittleEndian.PutUint32(buff[12:], _this.rcvNxt)
+	copy(buff[kcpHeadLen:], seg.data)
+
+	_this.output(buff, _this.remote)
+}
+
+// 连接 kcp 服务端, 客户端会话独占一个 udp 连接, conv 随机生成
+// udp 无连接, 服务端不可达时在发送数据超过重传次数后才销毁会话
+func dialKcp(network string, addr string) (*kcpSession, error) {
+	remote, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP(network, nil, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv [4]byte
+	if _, err = rand.Read(conv[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sess := newKcpSession(binary.LittleEndian.Uint32(conv[:]), remote, func(data []byte, _ *net.UDPAddr) error {
+		_, err := conn.Write(data)
+		return err
+	})
+	sess.onDie = func(*kcpSession) {
+		conn.Close()
+	}
+
+	go kcpClientReader(conn, sess)
+	return sess, nil
+}
+
+// 客户端读取 udp 包, udp 连接出错后销毁会话
+func kcpClientReader(conn *net.UDPConn, sess *kcpSession) {
+	buff := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			sess.destroy(err)
+			return
+		}
+
+		// 分片的数据在 input 中复制
+		if n < kcpHeadLen || binary.LittleEndian.Uint32(buff) != sess.conv {
+			continue
+		}
+		sess.input(buff[:n])
+	}
+}
+
+// kcp 监听, 按客户端地址区分会话, 所有会话共用一个 udp 连接
+type kcpListener struct {
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	sessions map[string]*kcpSession
+	accept   chan *kcpSession
+	closing  bool
+}
+
+func listenKcp(network string, addr *net.UDPAddr) (*kcpListener, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &kcpListener{
+		conn:     conn,
+		sessions: make(map[string]*kcpSession),
+		accept:   make(chan *kcpSession, 128),
+	}
+
+	go l.reader()
+	return l, nil
+}
+
+func (_this *kcpListener) reader() {
+	defer close(_this.accept)
+
+	buff := make([]byte, 65536)
+	for {
+		n, addr, err := _this.conn.ReadFromUDP(buff)
+		if err != nil {
+			return
+		}
+
+		if n < kcpHeadLen {
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buff[:n])
+		_this.input(addr, data)
+	}
+}
+
+func (_this *kcpListener) input(addr *net.UDPAddr, data []byte) {
+	conv := binary.LittleEndian.Uint32(data)
+
+	_this.mu.Lock()
+	sess, ok := _this.sessions[addr.String()]
+
+	// 相同地址的其他 conv, 可能是伪造的地址, 丢弃
+	// 旧会话空闲超过 kcpConvIdle 时认为客户端使用相同地址建立了新会话, 销毁旧会话
+	if ok && sess.conv != conv {
+		if data[4] != kcpCmdPush || time.Since(sess.active) < kcpConvIdle {
+			_this.mu.Unlock()
+			return
+		}
+
+		_this.mu.Unlock()
+		sess.destroy(errKcpClosed)
+		_this.input(addr, data)
+		return
+	}
+
+	if !ok {
+		// 只有数据包可以创建新会话
+		if _this.closing || data[4] != kcpCmdPush {
+			_this.mu.Unlock()
+			return
+		}
+
+		sess = newKcpSession(conv, addr, _this.output)
+		sess.onDie = _this.remove
+
+		select {
+		case _this.accept <- sess:
+			_this.sessions[addr.String()] = sess
+		default:
+			// 等待建立的会话过多, 丢弃
+			_this.mu.Unlock()
+			sess.destroy(errKcpClosed)
+			return
+		}
+	}
+	sess.active = time.Now()
+	_this.mu.Unlock()
+
+	sess.input(data)
+}
+
+func (_this *kcpListener) output(data []byte, addr *net.UDPAddr) error {
+	_, err := _this.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// 会话销毁后移除, 监听已关闭且没有会话时关闭 udp 连接
+func (_this *kcpListener) remove(sess *kcpSession) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.sessions[sess.remote.String()] == sess {
+		delete(_this.sessions, sess.remote.String())
+	}
+
+	if _this.closing && len(_this.sessions) == 0 {
+		_this.conn.Close()
+	}
+}
+
+// 接受新会话, 监听关闭后返回错误
+func (_this *kcpListener) Accept() (*kcpSession, error) {
+	sess, ok := <-_this.accept
+	if !ok {
+		return nil, errKcpClosed
+	}
+
+	return sess, nil
+}
+
+// 停止接受新会话, 已建立的会话全部销毁后关闭 udp 连接
+func (_this *kcpListener) Close() error {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.closing {
+		return nil
+	}
+	_this.closing = true
+
+	if len(_this.sessions) == 0 {
+		return _this.conn.Close()
+	}
+
+	return nil
+}
+
+// 非阻塞通知
+func notify(c chan bool) {
+	select {
+	case c <- true:
+	default:
+	}
+}
+
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
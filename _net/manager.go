@@ -0,0 +1,80 @@
+package _net
+
+import "sync"
+
+// 连接管理器, 记录 socket 下所有在线的连接
+type connManager struct {
+	mu    sync.RWMutex
+	conns map[int]IConn
+	// socket 关闭中, 不再添加连接
+	closed bool
+}
+
+func newConnManager() *connManager {
+	return &connManager{
+		conns: make(map[int]IConn),
+	}
+}
+
+// 添加连接, 调用 close 之后返回 false
+func (_this *connManager) add(conn IConn) bool {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _this.closed {
+		return false
+	}
+
+	_this.conns[conn.GetConnID()] = conn
+	return true
+}
+
+// 不再添加连接, 之前添加的连接都会出现在之后的遍历中
+func (_this *connManager) close() {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.closed = true
+}
+
+// 移除连接
+func (_this *connManager) remove(id int) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	delete(_this.conns, id)
+}
+
+// 获取连接
+func (_this *connManager) get(id int) (IConn, bool) {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	conn, ok := _this.conns[id]
+	return conn, ok
+}
+
+// 在线连接数量
+func (_this *connManager) count() int {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	return len(_this.conns)
+}
+
+// 遍历连接, f 返回 false 时停止遍历
+// 遍历的是连接快照, f 中可以安全的调用 Stop 等会修改管理器的方法
+func (_this *connManager) each(f func(IConn) bool) {
+	_this.mu.RLock()
+	conns := make([]IConn, 0, len(_this.conns))
+	for _, conn := range _this.conns {
+		conns = append(conns, conn)
+	}
+	_this.mu.RUnlock()
+
+	for _, conn := range conns {
+		if !f(conn) {
+			return
+		}
+	}
+}
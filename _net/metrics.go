@@ -0,0 +1,92 @@
+package _net
+
+import (
+	"fmt"
+	"github.com/fly-way/gofly/metrics"
+	"strconv"
+	"time"
+)
+
+// 网络层指标, 通过 RegProfListen 开启的 http 服务的 /metrics 输出
+// socket 标签为 socket 的名称, 如 "tcp://127.0.0.1:9999", 客户端为 "tcp://client"
+var (
+	connOpened   = metrics.NewCounter("gofly_net_conn_opened_total", "Connections opened.", "socket")
+	connClosed   = metrics.NewCounter("gofly_net_conn_closed_total", "Connections closed.", "socket")
+	connOnline   = metrics.NewGauge("gofly_net_conn_online", "Connections online.", "socket")
+	bytesIn      = metrics.NewCounter("gofly_net_bytes_in_total", "Bytes received, including frame headers.", "socket")
+	bytesOut     = metrics.NewCounter("gofly_net_bytes_out_total", "Bytes sent, including frame headers.", "socket")
+	framesIn     = metrics.NewCounter("gofly_net_frames_in_total", "Frames received.", "socket")
+	framesOut    = metrics.NewCounter("gofly_net_frames_out_total", "Frames sent.", "socket")
+	msgIn        = metrics.NewCounter("gofly_net_msg_total", "Messages received per message ID, unregistered IDs are counted as other.", "socket", "msg_id")
+	workerQueue  = metrics.NewGauge("gofly_net_worker_queue", "Tasks waiting in each worker queue.", "socket", "worker")
+	handlerTime  = metrics.NewHistogram("gofly_net_handler_seconds", "Handler latency per message ID.", nil, "socket", "msg_id")
+	cryptoErrors = metrics.NewCounter("gofly_net_crypto_errors_total", "Frames that failed to encrypt or decrypt, or were rejected as replayed.", "socket", "op")
+)
+
+// socket 名称, 用于指标的 socket 标签
+func (_this *socket) setName(host string, port int) {
+	_this.name = fmt.Sprintf("%s://%s:%d", _this.network, host, port)
+}
+
+// 注册采集回调, 采集时更新在线连接数和 worker 队列长度
+func (_this *socket) regMetrics() {
+	workers := 0
+
+	_this.unregMetrics = metrics.RegCollect(func() {
+		connOnline.Set(float64(_this.conns.count()), _this.name)
+
+		stats := _this.WorkerStats()
+		for i, v := range stats {
+			workerQueue.Set(float64(v.Queue), _this.name, strconv.Itoa(i))
+		}
+
+		// 清理缩小 worker 池后已经不存在的 worker
+		for i := len(stats); i < workers; i++ {
+			workerQueue.Delete(_this.name, strconv.Itoa(i))
+		}
+		workers = len(stats)
+	})
+}
+
+// 收到一帧
+func (_this *socket) countFrameIn(size int) {
+	framesIn.Inc(_this.name)
+	bytesIn.Add(float64(size), _this.name)
+}
+
+// 发送一帧
+func (_this *socket) countFrameOut(size int) {
+	framesOut.Inc(_this.name)
+	bytesOut.Add(float64(size), _this.name)
+}
+
+// 收到一条消息
+func (_this *socket) countMsg(msgID uint32) {
+	msgIn.Inc(_this.name, _this.msgLabel(msgID))
+}
+
+// 处理函数耗时
+func (_this *socket) observeHandler(msgID uint32, begin time.Time) {
+	handlerTime.Observe(time.Since(begin).Seconds(), _this.name, _this.msgLabel(msgID))
+}
+
+// 消息ID标签, 防止对端发送任意消息ID导致标签无限增长
+// 注册了单个路由, 消息类型的消息ID和心跳单独统计, 区间路由按区间统计, 其余统计为 "other"
+func (_this *socket) msgLabel(msgID uint32) string {
+	if _this.isHeartbeat(msgID) {
+		return strconv.FormatUint(uint64(msgID), 10)
+	}
+
+	if label, ok := _this.router.label(msgID); ok {
+		return label
+	}
+
+	if _, ok := _this.msgTypes.Load(msgID); ok {
+		return strconv.FormatUint(uint64(msgID), 10)
+	}
+	if _, ok := _this.replyTypes.Load(msgID); ok {
+		return strconv.FormatUint(uint64(msgID), 10)
+	}
+
+	return "other"
+}
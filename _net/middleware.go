@@ -0,0 +1,73 @@
+package _net
+
+import (
+	"github.com/fly-way/gofly/logs"
+	"time"
+)
+
+func (_this *socket) Use(middleware func(next Handler) Handler) {
+	if middleware == nil {
+		logs.Panic("middleware nil")
+	}
+
+	_this.middlewares = append(_this.middlewares, middleware)
+}
+
+// 用中间件包装路由, 先添加的中间件在外层
+func (_this *socket) chain() Handler {
+	handler := Handler(_this.router.handle)
+	for i := len(_this.middlewares) - 1; i >= 0; i-- {
+		handler = _this.middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// 统计请求的处理耗时, 处理完成后回调 call, call 为 nil 时输出 debug 日志
+func TimingMiddleware(call func(request Request, cost time.Duration)) func(next Handler) Handler {
+	return func(next Handler) Handler {
+		return func(request Request) {
+			begin := time.Now()
+			next(request)
+
+			if call != nil {
+				call(request, time.Since(begin))
+			} else {
+				logs.Debug("request[conn,msgID,cost]:", request.Conn.GetConnID(), request.ID, time.Since(begin))
+			}
+		}
+	}
+}
+
+// 处理请求前输出 debug 日志, 包括连接ID, 消息ID和消息内容
+func DebugMiddleware() func(next Handler) Handler {
+	return func(next Handler) Handler {
+		return func(request Request) {
+			if logs.IsDebug() {
+				logs.Debug("request[conn,msgID,msgData]:", request.Conn.GetConnID(), request.ID, request.Data)
+			}
+			next(request)
+		}
+	}
+}
+
+// 要求连接设置了属性 attr, 如登录成功后设置的用户ID, 未设置时丢弃请求并输出 debug 日志
+// allow 为不需要校验的消息ID, 如登录消息
+func AuthMiddleware(attr interface{}, allow ...uint32) func(next Handler) Handler {
+	skip := make(map[uint32]bool, len(allow))
+	for _, id := range allow {
+		skip[id] = true
+	}
+
+	return func(next Handler) Handler {
+		return func(request Request) {
+			if !skip[request.ID] {
+				if _, ok := request.Conn.QueryAttr().Load(attr); !ok {
+					logs.Debug("request unauthorized[conn,msgID]:", request.Conn.GetConnID(), request.ID)
+					return
+				}
+			}
+			next(request)
+		}
+	}
+}
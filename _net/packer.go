@@ -0,0 +1,206 @@
+package _net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+)
+
+// 获取消息打包方式, 未设置时使用默认格式
+// tcp: len uint32 + id uint32
+// websocket: id uint32
+// 开启 SetCompress 时在默认格式之后追加 flags uint8
+// 开启 SetSeq 时在消息头之后追加 seq uint32
+func (_this *socket) getPacker() IPacker {
+	packer := _this.packer
+	if packer == nil {
+		switch {
+		case _this.network == "tcp" && _this.compress != CompressNone:
+			packer = defaultTcpFlagsPacker
+		case _this.network == "tcp":
+			packer = defaultTcpPacker
+		case _this.compress != CompressNone:
+			packer = defaultWsFlagsPacker
+		default:
+			packer = defaultWsPacker
+		}
+	}
+
+	if _this.seq {
+		return seqPacker{IPacker: packer}
+	}
+
+	return packer
+}
+
+// 检查消息头格式, tcp 依赖 len 字段划分消息边界, 开启压缩时依赖 flags 字段标记压缩, 不满足时 panic
+func (_this *socket) checkPacker() {
+	if _this.packer == nil {
+		return
+	}
+
+	body := []byte{0}
+	msg, err := _this.packer.Pack(PacketHead{Flags: FlagCompressed}, body, _this.byteOrder)
+	if err != nil {
+		logs.Panic("packer check err:", err)
+	}
+
+	head, err := _this.packer.UnPack(msg, _this.byteOrder)
+	if err != nil {
+		logs.Panic("packer check err:", err)
+	}
+
+	if _this.network == "tcp" && head.Len != uint32(len(body)) {
+		logs.Panic("tcp packer must have len field")
+	}
+
+	if _this.compress != CompressNone && head.Flags&FlagCompressed == 0 {
+		logs.Panic("compress packer must have flags field")
+	}
+}
+
+var (
+	defaultTcpPacker      = NewPacker(4, 4, 0)
+	defaultWsPacker       = NewPacker(0, 4, 0)
+	defaultTcpFlagsPacker = NewPacker(4, 4, 1)
+	defaultWsFlagsPacker  = NewPacker(0, 4, 1)
+)
+
+// 按字段长度打包消息头, 消息头依次为 len, id, flags 字段
+// lenSize, idSize, flagsSize 分别为各字段的字节数, 可选 0, 1, 2, 4, 0 表示没有该字段
+// 如 NewPacker(2, 2, 1) 表示 2 字节长度 + 2 字节消息ID + 1 字节标记位
+// 没有 len 字段时只能用于 websocket 这类自带消息边界的协议
+func NewPacker(lenSize, idSize, flagsSize int) IPacker {
+	for _, v := range []int{lenSize, idSize, flagsSize} {
+		switch v {
+		case 0, 1, 2, 4:
+		default:
+			logs.Panic(fmt.Sprintf("packer field size invalid: %d", v))
+		}
+	}
+
+	return &packer{lenSize: lenSize, idSize: idSize, flagsSize: flagsSize}
+}
+
+type packer struct {
+	lenSize   int
+	idSize    int
+	flagsSize int
+}
+
+func (_this *packer) HeadLen() int {
+	return _this.lenSize + _this.idSize + _this.flagsSize
+}
+
+func (_this *packer) Pack(head PacketHead, body []byte, order binary.ByteOrder) ([]byte, error) {
+	if _this.lenSize > 0 && uint64(len(body)) > fieldMax(_this.lenSize) {
+		return nil, errors.New("msg data long")
+	}
+
+	if uint64(head.ID) > fieldMax(_this.idSize) {
+		return nil, fmt.Errorf("msg id overflow: %d", head.ID)
+	}
+
+	headLen := _this.HeadLen()
+	buff := make([]byte, headLen+len(body))
+
+	offset := putField(buff, _this.lenSize, uint32(len(body)), order)
+	offset += putField(buff[offset:], _this.idSize, head.ID, order)
+	putField(buff[offset:], _this.flagsSize, uint32(head.Flags), order)
+	copy(buff[headLen:], body)
+
+	return buff, nil
+}
+
+func (_this *packer) UnPack(data []byte, order binary.ByteOrder) (PacketHead, error) {
+	var head PacketHead
+
+	if len(data) < _this.HeadLen() {
+		return head, errors.New("msg data short")
+	}
+
+	offset := 0
+	head.Len = getField(data[offset:], _this.lenSize, order)
+	offset += _this.lenSize
+	head.ID = getField(data[offset:], _this.idSize, order)
+	offset += _this.idSize
+	head.Flags = uint8(getField(data[offset:], _this.flagsSize, order))
+
+	return head, nil
+}
+
+// 序列号字段长度
+const seqSize = 4
+
+// 在原消息头之后追加序列号字段
+type seqPacker struct {
+	IPacker
+}
+
+func (_this seqPacker) HeadLen() int {
+	return _this.IPacker.HeadLen() + seqSize
+}
+
+func (_this seqPacker) Pack(head PacketHead, body []byte, order binary.ByteOrder) ([]byte, error) {
+	msg, err := _this.IPacker.Pack(head, body, order)
+	if err != nil {
+		return nil, err
+	}
+
+	headLen := _this.IPacker.HeadLen()
+	buff := make([]byte, len(msg)+seqSize)
+
+	copy(buff, msg[:headLen])
+	order.PutUint32(buff[headLen:], head.Seq)
+	copy(buff[headLen+seqSize:], msg[headLen:])
+
+	return buff, nil
+}
+
+func (_this seqPacker) UnPack(data []byte, order binary.ByteOrder) (PacketHead, error) {
+	if len(data) < _this.HeadLen() {
+		return PacketHead{}, errors.New("msg data short")
+	}
+
+	head, err := _this.IPacker.UnPack(data, order)
+	if err != nil {
+		return head, err
+	}
+
+	head.Seq = order.Uint32(data[_this.IPacker.HeadLen():])
+	return head, nil
+}
+
+// 字段最大值
+func fieldMax(size int) uint64 {
+	return 1<<(uint(size)*8) - 1
+}
+
+// 写入字段, 返回字段长度
+func putField(buff []byte, size int, v uint32, order binary.ByteOrder) int {
+	switch size {
+	case 1:
+		buff[0] = byte(v)
+	case 2:
+		order.PutUint16(buff, uint16(v))
+	case 4:
+		order.PutUint32(buff, v)
+	}
+
+	return size
+}
+
+// 读取字段
+func getField(buff []byte, size int, order binary.ByteOrder) uint32 {
+	switch size {
+	case 1:
+		return uint32(buff[0])
+	case 2:
+		return uint32(order.Uint16(buff))
+	case 4:
+		return order.Uint32(buff)
+	}
+
+	return 0
+}
@@ -0,0 +1,67 @@
+package _net
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/fly-way/gofly/logs"
+	"sync/atomic"
+)
+
+// 允许乱序的帧数量, 多个 goroutine 同时发送时帧的顺序可能与计数器的顺序不一致
+const replayWindow = 64
+
+// 计数器长度
+const counterSize = 8
+
+func (_this *socket) SetReplayProtect(enable bool) {
+	_this.replayProtect = enable
+}
+
+// 检查防重放配置, 未开启握手时所有连接共用密钥, 帧可以在新连接中重放, 不满足时 panic
+func (_this *socket) checkReplay() {
+	if _this.replayProtect && !_this.isHandshake() {
+		logs.Panic("replay protect requires handshake, server SetHandshakeKey, client SetHandshakePin")
+	}
+}
+
+// 在明文前追加发送计数器, 计数器从 1 开始递增
+func (_this *connCipher) addCounter(data []byte) []byte {
+	buff := make([]byte, counterSize+len(data))
+	binary.BigEndian.PutUint64(buff, atomic.AddUint64(&_this.sendCounter, 1))
+	copy(buff[counterSize:], data)
+
+	return buff
+}
+
+// 校验并去掉明文前的计数器, 重复或超出窗口的帧返回 ErrReplayFrame, 只在连接的 reader 中调用
+func (_this *connCipher) checkCounter(data []byte) ([]byte, error) {
+	if len(data) < counterSize {
+		return nil, errors.New("frame counter short")
+	}
+
+	n := binary.BigEndian.Uint64(data)
+	if n == 0 {
+		return nil, ErrReplayFrame
+	}
+
+	switch {
+	case n > _this.recvMax:
+		// 新的最大值, 窗口向前滑动
+		if shift := n - _this.recvMax; shift < replayWindow {
+			_this.recvWindow = _this.recvWindow<<shift | 1
+		} else {
+			_this.recvWindow = 1
+		}
+		_this.recvMax = n
+	case _this.recvMax-n >= replayWindow:
+		return nil, ErrReplayFrame
+	default:
+		bit := uint64(1) << (_this.recvMax - n)
+		if _this.recvWindow&bit != 0 {
+			return nil, ErrReplayFrame
+		}
+		_this.recvWindow |= bit
+	}
+
+	return data[counterSize:], nil
+}
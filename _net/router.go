@@ -0,0 +1,159 @@
+package _net
+
+import (
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 消息ID区间路由, [begin, end] 闭区间
+type rangeRoute struct {
+	begin   uint32
+	end     uint32
+	handler func(Request)
+}
+
+// 消息路由, 根据消息ID把请求分发给对应的处理函数
+type router struct {
+	mu       sync.RWMutex
+	routes   map[uint32]func(Request)
+	ranges   []rangeRoute
+	notFound func(Request)
+}
+
+func newRouter() *router {
+	return &router{
+		routes: make(map[uint32]func(Request)),
+		notFound: func(r Request) {
+			logs.Debug("request[conn,msgID,msgData]:", r.Conn.GetConnID(), r.ID, r.Data)
+		},
+	}
+}
+
+// 注册单个消息ID的路由
+func (_this *router) add(msgID uint32, handler func(Request)) {
+	if handler == nil {
+		logs.Panic("route handler nil, msgID:", msgID)
+	}
+
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _, ok := _this.routes[msgID]; ok {
+		logs.Panic("route msgID repeat, msgID:", msgID)
+	}
+	_this.routes[msgID] = handler
+}
+
+// 注册消息ID区间路由, 区间之间不允许重叠
+func (_this *router) addRange(begin, end uint32, handler func(Request)) {
+	if handler == nil {
+		logs.Panic("route handler nil, range:", begin, end)
+	}
+
+	if begin > end {
+		logs.Panic("route range invalid, range:", begin, end)
+	}
+
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	for _, v := range _this.ranges {
+		if begin <= v.end && end >= v.begin {
+			logs.Panic(fmt.Sprintf("route range overlap, [%d,%d] with [%d,%d]", begin, end, v.begin, v.end))
+		}
+	}
+
+	_this.ranges = append(_this.ranges, rangeRoute{begin: begin, end: end, handler: handler})
+	sort.Slice(_this.ranges, func(i, j int) bool {
+		return _this.ranges[i].begin < _this.ranges[j].begin
+	})
+}
+
+// 设置未命中路由时的处理函数
+func (_this *router) setNotFound(handler func(Request)) {
+	if handler == nil {
+		return
+	}
+
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.notFound = handler
+}
+
+// 查找消息ID对应的处理函数, 优先匹配单个消息ID, 其次匹配区间
+func (_this *router) match(msgID uint32) func(Request) {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	if handler, ok := _this.routes[msgID]; ok {
+		return handler
+	}
+
+	idx := sort.Search(len(_this.ranges), func(i int) bool {
+		return _this.ranges[i].end >= msgID
+	})
+	if idx < len(_this.ranges) && _this.ranges[idx].begin <= msgID {
+		return _this.ranges[idx].handler
+	}
+
+	return _this.notFound
+}
+
+// 消息ID的指标标签, 单个消息ID的路由为消息ID, 区间路由为 "begin-end", 未命中路由时返回 false
+func (_this *router) label(msgID uint32) (string, bool) {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	if _, ok := _this.routes[msgID]; ok {
+		return strconv.FormatUint(uint64(msgID), 10), true
+	}
+
+	idx := sort.Search(len(_this.ranges), func(i int) bool {
+		return _this.ranges[i].end >= msgID
+	})
+	if idx < len(_this.ranges) && _this.ranges[idx].begin <= msgID {
+		return fmt.Sprintf("%d-%d", _this.ranges[idx].begin, _this.ranges[idx].end), true
+	}
+
+	return "", false
+}
+
+// 处理请求, 作为 worker 池的任务处理回调
+func (_this *router) handle(request Request) {
+	_this.match(request.ID)(request)
+}
+
+// 已注册的路由列表, 按消息ID升序排列
+func (_this *router) list() []RouteInfo {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	infos := make([]RouteInfo, 0, len(_this.routes)+len(_this.ranges))
+	for id, handler := range _this.routes {
+		infos = append(infos, RouteInfo{Begin: id, End: id, Handler: funcName(handler)})
+	}
+	for _, v := range _this.ranges {
+		infos = append(infos, RouteInfo{Begin: v.begin, End: v.end, Handler: funcName(v.handler)})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Begin < infos[j].Begin
+	})
+
+	return infos
+}
+
+// 获取函数名称
+func funcName(f interface{}) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+
+	return "unknown"
+}
@@ -1,13 +1,15 @@
 package _net
 
 import (
+	"context"
 	"github.com/fly-way/gofly/logs"
+	"github.com/fly-way/gofly/metrics"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strconv"
-	"time"
+	"sync"
 )
 
 
@@ -15,14 +17,26 @@ type server struct {
 	profPort      int
 	signals       []os.Signal
 	signalCall    func(os.Signal)
+	mu            sync.Mutex
+	sockets       []*socket
+	profServer    *http.Server
+	adminAddr     string
+	adminToken    string
+	adminServer   *http.Server
+	done          chan bool
+	doneOnce      sync.Once
 }
 
 func (_this *server) TcpServe() ISocket {
-	return newSocket("tcp")
+	return _this.addSocket(newSocket("tcp"))
 }
 
 func (_this *server) WebsocketServe() ISocket {
-	return newSocket("websocket")
+	return _this.addSocket(newSocket("websocket"))
+}
+
+func (_this *server) KcpServe() ISocket {
+	return _this.addSocket(newSocket("kcp"))
 }
 
 
@@ -55,15 +69,34 @@ func (_this *server) Start() {
 		// 开启对阻塞操作的跟踪
 		runtime.SetBlockProfileRate(1)
 		addr := "127.0.0.1" + ":" + strconv.Itoa(_this.profPort)
+
+		_this.mu.Lock()
+		_this.profServer = &http.Server{Addr: addr, Handler: profHandler()}
+		_this.mu.Unlock()
+
 		go func() {
-			http.ListenAndServe(addr, nil)
+			_this.profServer.ListenAndServe()
+		}()
+	}
+
+	// 管理接口
+	if _this.adminAddr != "" {
+		_this.mu.Lock()
+		_this.adminServer = &http.Server{Addr: _this.adminAddr, Handler: _this.adminHandler()}
+		_this.mu.Unlock()
+
+		logs.System("admin listen:", _this.adminAddr)
+		go func() {
+			if err := _this.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logs.Error("admin listen err:", err)
+			}
 		}()
 	}
 
 	// 信号处理
 	if _this.signalCall != nil {
 		go func() {
-			c := make(chan os.Signal)
+			c := make(chan os.Signal, 1)
 			signal.Notify(c, _this.signals...)
 			for sig := range c {
 				_this.signalCall(sig)
@@ -72,13 +105,74 @@ func (_this *server) Start() {
 		}()
 	}
 
-	// 阻塞主线程
-	for {
-		time.Sleep(60 * time.Second)
-	}
+	// 阻塞主线程, 直到服务关闭
+	<-_this.done
 }
 
 func (_this *server) Stop() {
+	_this.Shutdown(context.Background())
+}
+
+func (_this *server) Shutdown(ctx context.Context) error {
+	logs.System("server stop begin!")
+
+	_this.mu.Lock()
+	sockets := _this.sockets
+	profServer := _this.profServer
+	adminServer := _this.adminServer
+	_this.mu.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	for _, v := range sockets {
+		wg.Add(1)
+		go func(s *socket) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(v)
+	}
+	wg.Wait()
+
+	if profServer != nil {
+		profServer.Shutdown(ctx)
+	}
+
+	if adminServer != nil {
+		adminServer.Shutdown(ctx)
+	}
+
+	_this.doneOnce.Do(func() {
+		close(_this.done)
+	})
+
 	logs.System("server stop!")
+	return firstErr
+}
+
+// prof 服务的处理函数, /metrics 输出 Prometheus 格式的指标, 其余路径交给 http.DefaultServeMux, 如 net/http/pprof
+func profHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", http.DefaultServeMux)
+
+	return mux
 }
 
+// 记录 server 创建的 socket, 关闭服务时统一关闭
+func (_this *server) addSocket(s *socket) *socket {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.sockets = append(_this.sockets, s)
+	return s
+}
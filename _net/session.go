@@ -0,0 +1,499 @@
+package _net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 会话令牌长度
+const sessionTokenSize = 16
+
+// 恢复会话的应答状态
+const (
+	resumeNew byte = iota
+	resumeOk
+)
+
+func (_this *socket) SetResume(grace time.Duration, bufferSize int) {
+	_this.resumeGrace = grace
+	_this.resumeBufferSize = bufferSize
+}
+
+func (_this *socket) SetConnResumeCall(connResume func(IConn)) {
+	_this.connResume = connResume
+}
+
+// 可以恢复会话的连接
+type sessionConn interface {
+	IConn
+	handshakeConn
+	// 接管会话的连接ID和加密状态
+	takeover(id int, cipher *connCipher)
+}
+
+// 会话, 连接断开后保留到超时, 期间发送的消息缓存在 buffer 中
+type session struct {
+	token []byte
+	id    int
+	mu    sync.Mutex
+	// 当前连接, 断开后为最后一条连接
+	conn sessionConn
+	// 正在接管会话的新连接
+	next   sessionConn
+	buffer [][]byte
+	// 连接断开后的超时时间, 为零值时连接未断开
+	deadline time.Time
+	timer    *time.Timer
+	ended    bool
+}
+
+// 会话管理器, 按令牌和连接ID记录所有会话
+type sessionManager struct {
+	mu     sync.Mutex
+	tokens map[string]*session
+	ids    map[int]*session
+}
+
+func newSessionManager() *sessionManager {
+	return &sessionManager{
+		tokens: make(map[string]*session),
+		ids:    make(map[int]*session),
+	}
+}
+
+func (_this *sessionManager) add(s *session) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.tokens[string(s.token)] = s
+	_this.ids[s.id] = s
+}
+
+func (_this *sessionManager) remove(s *session) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	delete(_this.tokens, string(s.token))
+	delete(_this.ids, s.id)
+}
+
+func (_this *sessionManager) byToken(token []byte) *session {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	return _this.tokens[string(token)]
+}
+
+func (_this *sessionManager) byID(id int) *session {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	return _this.ids[id]
+}
+
+// 所有会话快照
+func (_this *sessionManager) all() []*session {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	sessions := make([]*session, 0, len(_this.ids))
+	for _, s := range _this.ids {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+// 是否开启会话恢复
+func (_this *socket) isResume() bool {
+	return _this.resumeGrace > 0
+}
+
+// 服务端恢复会话, 收到客户端的令牌后恢复令牌对应的会话, 令牌为空或会话已结束时创建新会话
+func (_this *socket) resume(conn sessionConn) error {
+	if !_this.isResume() || _this.client {
+		return nil
+	}
+
+	conn.setHandshakeDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.setHandshakeDeadline(time.Time{})
+
+	token, err := _this.readResume(conn)
+	if err != nil {
+		return fmt.Errorf("resume err: %v", err)
+	}
+
+	status := resumeNew
+	s := _this.sessions.byToken(token)
+	if s != nil && _this.claimSession(s, conn) {
+		status = resumeOk
+	} else if s, err = newSession(conn); err != nil {
+		return fmt.Errorf("resume err: %v", err)
+	}
+
+	if err = _this.writeResume(conn, append([]byte{status}, s.token...)); err != nil {
+		if status == resumeOk {
+			_this.unclaimSession(s)
+		}
+		return fmt.Errorf("resume err: %v", err)
+	}
+
+	// 应答使用新连接的加密状态, 之后沿用原连接的加密状态
+	if status == resumeOk {
+		conn.takeover(s.id, s.conn.getCipher())
+	} else {
+		_this.sessions.add(s)
+	}
+
+	return nil
+}
+
+// 客户端恢复会话, 发送上一条连接的令牌, 服务端恢复会话后接管上一条连接的会话
+func (_this *socket) clientResume(conn sessionConn, prev IConn) error {
+	if !_this.isResume() {
+		return nil
+	}
+
+	conn.setHandshakeDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.setHandshakeDeadline(time.Time{})
+
+	var (
+		s     *session
+		token []byte
+	)
+	if prev != nil {
+		if s = _this.sessions.byID(prev.GetConnID()); s != nil && _this.claimSession(s, conn) {
+			token = s.token
+		} else {
+			s = nil
+		}
+	}
+
+	err := _this.writeResume(conn, token)
+	var msg []byte
+	if err == nil {
+		msg, err = _this.readResume(conn)
+	}
+	if err == nil && len(msg) != 1+sessionTokenSize {
+		err = errors.New("resume reply invalid")
+	}
+
+	if err != nil {
+		if s != nil {
+			_this.unclaimSession(s)
+		}
+		return fmt.Errorf("resume err: %v", err)
+	}
+
+	if s != nil && msg[0] == resumeOk && bytes.Equal(msg[1:], s.token) {
+		conn.takeover(s.id, s.conn.getCipher())
+		return nil
+	}
+
+	// 服务端未恢复会话, 上一条连接的会话结束
+	if s != nil {
+		_this.endSession(s)
+	}
+	_this.sessions.add(&session{token: msg[1:], id: conn.GetConnID(), conn: conn})
+
+	return nil
+}
+
+func newSession(conn sessionConn) (*session, error) {
+	token := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	return &session{token: token, id: conn.GetConnID(), conn: conn}, nil
+}
+
+// 读取恢复会话的消息
+func (_this *socket) readResume(conn sessionConn) ([]byte, error) {
+	msg, err := conn.readHandshake()
+	if err != nil {
+		return nil, err
+	}
+
+	return _this.decrypt(conn.getCipher(), msg, nil)
+}
+
+// 写入恢复会话的消息, 开启加密时加密, 防止令牌泄露
+func (_this *socket) writeResume(conn sessionConn, msg []byte) error {
+	msg, err := _this.encrypt(conn.getCipher(), msg, nil)
+	if err != nil {
+		return err
+	}
+
+	return conn.writeHandshake(msg)
+}
+
+// 新连接认领会话, 原连接未断开时先断开原连接
+func (_this *socket) claimSession(s *session, conn sessionConn) bool {
+	s.mu.Lock()
+	if s.ended || s.next != nil {
+		s.mu.Unlock()
+		return false
+	}
+	s.next = conn
+	old := s.conn
+	s.mu.Unlock()
+
+	// 客户端切换网络时, 服务端可能还未发现原连接断开
+	stopConn(old, ErrConnResumed)
+
+	// 等待原连接停止读取, 之后才能沿用原连接的加密状态
+	if c, ok := old.(drainConn); ok {
+		<-c.stopRead()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return false
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	return true
+}
+
+// 恢复会话失败, 取消认领, 会话继续等待到原来的超时时间
+func (_this *socket) unclaimSession(s *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = nil
+	s.timer = time.AfterFunc(time.Until(s.deadline), func() {
+		_this.expireSession(s)
+	})
+}
+
+// 接管会话, 复制原连接的属性和分组, 发送缓存的消息, 返回 conn 是否恢复了会话
+func (_this *socket) attachSession(conn sessionConn) bool {
+	if !_this.isResume() {
+		return false
+	}
+
+	s := _this.sessions.byID(conn.GetConnID())
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	if s.next != conn {
+		s.mu.Unlock()
+		return false
+	}
+
+	old := s.conn
+	buffer := s.buffer
+	s.conn, s.next, s.buffer = conn, nil, nil
+	s.deadline, s.timer = time.Time{}, nil
+	s.mu.Unlock()
+
+	old.QueryAttr().Range(func(key, value interface{}) bool {
+		conn.QueryAttr().Store(key, value)
+		return true
+	})
+	_this.groups.rejoin(conn)
+
+	for _, msg := range buffer {
+		conn.WriteMsg(msg)
+	}
+
+	return true
+}
+
+// 连接开始处理消息前失败, 取消认领的会话或移除新建的会话
+func (_this *socket) abortSession(conn IConn) {
+	if !_this.isResume() {
+		return
+	}
+
+	s := _this.sessions.byID(conn.GetConnID())
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	claimed := s.next != nil && IConn(s.next) == conn
+	created := IConn(s.conn) == conn
+	if created {
+		s.ended = true
+	}
+	s.mu.Unlock()
+
+	if claimed {
+		_this.unclaimSession(s)
+	} else if created {
+		_this.sessions.remove(s)
+	}
+}
+
+// 连接断开时调用, 网络原因断开时保留会话等待恢复, 否则会话结束, 返回是否保留
+func (_this *socket) releaseSession(conn IConn, reason error) bool {
+	if !_this.isResume() {
+		return false
+	}
+
+	s := _this.sessions.byID(conn.GetConnID())
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != conn {
+		return false
+	}
+
+	if _this.isClosing() || (s.next == nil && !resumable(reason)) {
+		s.ended = true
+		_this.sessions.remove(s)
+		return false
+	}
+
+	return true
+}
+
+// 保留会话, 连接中未发送的消息移入缓存, 超时后会话结束
+func (_this *socket) detachSession(conn IConn, queue chan []byte) {
+	s := _this.sessions.byID(conn.GetConnID())
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+drain:
+	for {
+		select {
+		case msg := <-queue:
+			_this.bufferMsg(s, msg)
+		default:
+			break drain
+		}
+	}
+
+	s.deadline = time.Now().Add(_this.resumeGrace)
+	if s.next == nil {
+		s.timer = time.AfterFunc(_this.resumeGrace, func() {
+			_this.expireSession(s)
+		})
+	}
+}
+
+// 连接断开后发送的消息, 会话保留中时缓存, 会话已恢复时转发给新连接
+func (_this *socket) forwardSession(conn IConn, msg []byte) {
+	if !_this.isResume() {
+		return
+	}
+
+	s := _this.sessions.byID(conn.GetConnID())
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	if s.conn != conn && s.deadline.IsZero() {
+		next := s.conn
+		s.mu.Unlock()
+		next.WriteMsg(msg)
+		return
+	}
+
+	_this.bufferMsg(s, msg)
+	s.mu.Unlock()
+}
+
+// 缓存消息, 超出数量时丢弃最早的消息, 调用前需要加锁
+func (_this *socket) bufferMsg(s *session, msg []byte) {
+	if _this.resumeBufferSize <= 0 {
+		return
+	}
+
+	s.buffer = append(s.buffer, msg)
+	if n := len(s.buffer) - _this.resumeBufferSize; n > 0 {
+		s.buffer = s.buffer[n:]
+	}
+}
+
+// 会话超时, 未被认领时结束会话
+func (_this *socket) expireSession(s *session) {
+	s.mu.Lock()
+	if s.ended || s.next != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+
+	_this.closeSession(s)
+}
+
+// 结束会话
+func (_this *socket) endSession(s *session) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+
+	_this.closeSession(s)
+}
+
+// 结束所有保留中的会话, socket 关闭时调用
+func (_this *socket) endSessions() {
+	for _, s := range _this.sessions.all() {
+		_this.endSession(s)
+	}
+}
+
+// 会话结束后清理, 离开分组并回调最后一条连接的 connStop
+func (_this *socket) closeSession(s *session) {
+	_this.sessions.remove(s)
+	_this.groups.leaveAll(s.id)
+
+	if _this.connStop != nil {
+		_this.connStop(s.conn)
+	}
+}
+
+// 网络原因断开的连接可以恢复会话, 主动断开或协议错误时不保留
+func resumable(reason error) bool {
+	switch reason {
+	case io.EOF, io.ErrUnexpectedEOF, ErrIdleTimeout, ErrWriteTimeout, ErrConnResumed:
+		return true
+	}
+
+	// websocket 未收到关闭帧时连接异常断开
+	if closeErr, ok := reason.(*websocket.CloseError); ok {
+		return closeErr.Code == websocket.CloseAbnormalClosure
+	}
+
+	_, ok := reason.(net.Error)
+	return ok
+}
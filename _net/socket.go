@@ -1,23 +1,87 @@
 package _net
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"fmt"
 	"github.com/fly-way/gofly/logs"
 	"github.com/gorilla/websocket"
+	"io"
 	"net"
 	"net/http"
+	"os"
+	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type socket struct {
-	network       string
-	key, iv       string
-	packetMaxSize int
-	byteOrder     binary.ByteOrder
-	connStart     func(IConn)
-	connStop      func(IConn)
-	workers       *workerPool
+	network             string
+	name                string
+	key, iv             string
+	gcm                 bool
+	replayProtect       bool
+	client              bool
+	handshakeKey        *ecdsa.PrivateKey
+	handshakePin        *ecdsa.PublicKey
+	packetMaxSize       int
+	byteOrder           binary.ByteOrder
+	connStart           func(IConn)
+	connStop            func(IConn)
+	connResume          func(IConn)
+	upgradeAuth         func(*http.Request, *sync.Map) int
+	frameAuth           func(Request) error
+	frameAuthTimeout    time.Duration
+	resumeGrace         time.Duration
+	resumeBufferSize    int
+	sessions            *sessionManager
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	heartbeat           bool
+	heartbeatID         uint32
+	sendQueueSize       int
+	backpressure        BackpressurePolicy
+	backpressureTimeout time.Duration
+	backpressureCall    func(IConn, BackpressurePolicy)
+	floodLimit          FloodLimit
+	floodCall           func(IConn, error)
+	bans                *banList
+	ipFilter            *ipFilter
+	panicCall           func(Request, interface{})
+	dispatchCall        func(*Request)
+	middlewares         []func(Handler) Handler
+	handler             Handler
+	panics              sync.Map
+	codec               ICodec
+	packer              IPacker
+	compress            Compression
+	compressThreshold   int
+	msgTypes            sync.Map
+	replyTypes          sync.Map
+	seq                 bool
+	workers             *workerPool
+	startOnce           sync.Once
+	unregMetrics        func()
+	// 引用客户端配置的客户端数量
+	clients int
+	router              *router
+	conns               *connManager
+	groups              *groupManager
+	connID              int64
+	closing             int32
+	mu                  sync.Mutex
+	listener            io.Closer
+	httpServer          *http.Server
+	tlsConfig           *tls.Config
+	certFile, keyFile   string
+	cert                atomic.Value
+	clientCAs           *x509.CertPool
+	tlsReload           chan os.Signal
 }
 
 func newSocket(network string) *socket {
@@ -25,14 +89,29 @@ func newSocket(network string) *socket {
 
 	return &socket{
 		network:       network,
+		name:          network + "://client",
 		packetMaxSize: 4096,
+		sendQueueSize: 4096,
 		byteOrder:     binary.BigEndian,
+		router:        newRouter(),
+		conns:         newConnManager(),
+		groups:        newGroupManager(),
+		sessions:      newSessionManager(),
+		bans:          newBanList(),
+		ipFilter:      newIpFilter(),
 	}
 }
 
 func (_this *socket) AesEncrypt(key string, iv string) {
 	_this.key = key
 	_this.iv = iv
+	_this.gcm = false
+}
+
+func (_this *socket) AesGcmEncrypt(key string) {
+	_this.key = key
+	_this.iv = ""
+	_this.gcm = true
 }
 
 func (_this *socket) SetConnStartCall(connStart func(IConn)) {
@@ -44,14 +123,168 @@ func (_this *socket) SetConnStopCall(connStop func(IConn)) {
 	_this.connStop = connStop
 }
 
+func (_this *socket) SetReadTimeout(timeout time.Duration) {
+	_this.readTimeout = timeout
+}
+
+func (_this *socket) SetWriteTimeout(timeout time.Duration) {
+	_this.writeTimeout = timeout
+}
+
+func (_this *socket) SetHeartbeat(msgID uint32) {
+	_this.heartbeat = true
+	_this.heartbeatID = msgID
+}
+
+// 是否为心跳消息
+func (_this *socket) isHeartbeat(msgID uint32) bool {
+	return _this.heartbeat && _this.heartbeatID == msgID
+}
+
 func (_this *socket) InitWorkerPool(poolSize int, taskSize int, request func(Request)) {
-	_this.workers = newWorkerPool(poolSize, taskSize, request)
+	_this.router.setNotFound(request)
+	_this.workers = newWorkerPool(poolSize, taskSize, _this.handleRequest)
+}
+
+// 处理 worker 池中的一个任务
+// 处理函数 panic 时只影响当前任务, 不影响 worker 和其他连接
+func (_this *socket) handleRequest(request Request) {
+	defer _this.releaseTask(request.Conn)
+	defer _this.observeHandler(request.ID, time.Now())
+	defer func() {
+		if err := recover(); err != nil {
+			_this.onPanic(request, err)
+		}
+	}()
+
+	_this.handler(request)
+}
+
+// 处理函数 panic, 记录堆栈和次数
+func (_this *socket) onPanic(request Request, err interface{}) {
+	logs.Stack("request panic, msg id: ", request.ID, " err: ", err)
+
+	count, _ := _this.panics.LoadOrStore(request.ID, new(int64))
+	atomic.AddInt64(count.(*int64), 1)
+
+	if _this.panicCall != nil {
+		_this.panicCall(request, err)
+	}
+}
+
+func (_this *socket) SetPanicCall(call func(Request, interface{})) {
+	_this.panicCall = call
+}
+
+func (_this *socket) PanicCounts() map[uint32]int64 {
+	counts := make(map[uint32]int64)
+	_this.panics.Range(func(key, value interface{}) bool {
+		counts[key.(uint32)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+
+	return counts
+}
+
+func (_this *socket) SetDispatchCall(call func(*Request)) {
+	_this.dispatchCall = call
+}
+
+// 执行分配 worker 前的回调, 回调 panic 时按处理函数的 panic 处理并返回 false
+func (_this *socket) dispatch(request *Request) (ok bool) {
+	if _this.dispatchCall == nil {
+		return true
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			_this.onPanic(*request, err)
+			ok = false
+		}
+	}()
+
+	_this.dispatchCall(request)
+	return true
+}
+
+func (_this *socket) ResizeWorkerPool(size int) {
+	_this.getWorkers().resize(size)
+	logs.System("worker pool resize:", size)
+}
+
+func (_this *socket) WorkerStats() []WorkerStat {
+	return _this.getWorkers().stats()
+}
+
+func (_this *socket) Route(msgID uint32, handler func(Request)) {
+	_this.router.add(msgID, handler)
+}
+
+func (_this *socket) RouteRange(begin uint32, end uint32, handler func(Request)) {
+	_this.router.addRange(begin, end, handler)
+}
+
+func (_this *socket) SetNotFound(handler func(Request)) {
+	_this.router.setNotFound(handler)
+}
+
+func (_this *socket) Routes() []RouteInfo {
+	return _this.router.list()
 }
 
 func (_this *socket) SetPacketMaxSize(size int) {
 	_this.packetMaxSize = size
 }
 
+func (_this *socket) SetSendQueueSize(size int) {
+	if size <= 0 {
+		logs.Panic("send queue size must be positive:", size)
+	}
+	_this.sendQueueSize = size
+}
+
+func (_this *socket) SetBackpressure(policy BackpressurePolicy, timeout time.Duration) {
+	_this.backpressure = policy
+	_this.backpressureTimeout = timeout
+}
+
+func (_this *socket) SetBackpressureCall(call func(IConn, BackpressurePolicy)) {
+	_this.backpressureCall = call
+}
+
+func (_this *socket) SetCodec(codec ICodec) {
+	_this.codec = codec
+}
+
+func (_this *socket) RegMsg(msgID uint32, msg interface{}) {
+	regType(&_this.msgTypes, msgID, msg)
+}
+
+func (_this *socket) RegReply(msgID uint32, msg interface{}) {
+	regType(&_this.replyTypes, msgID, msg)
+}
+
+// 注册消息ID对应的类型, msg 为指针时注册指向的类型
+func regType(types *sync.Map, msgID uint32, msg interface{}) {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		logs.Panic("reg msg nil, msgID:", msgID)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	types.Store(msgID, t)
+}
+
+func (_this *socket) SetSeq(enable bool) {
+	_this.seq = enable
+}
+
+func (_this *socket) SetPacker(packer IPacker) {
+	_this.packer = packer
+}
+
 func (_this *socket) SetByteOrder(bigOrder bool) {
 	if bigOrder {
 		_this.byteOrder = binary.BigEndian
@@ -61,81 +294,350 @@ func (_this *socket) SetByteOrder(bigOrder bool) {
 }
 
 func (_this *socket) Listen(host string, port int, param string) {
-	if _this.workers == nil {
-		_this.workers = newWorkerPool(1, 256, func(r Request){
-			logs.Debug("request[conn,msgID,msgData]:", r.Conn.GetConnID(), r.ID, r.Data)
-		})
-	}
-	_this.workers.start()
+	_this.setName(host, port)
+	_this.startWorkers()
 
 	switch _this.network {
 	case "tcp":
-		switch param {
-		case "tcp", "tcp4", "tcp6", "":
-			logs.System("tcp listen, host:", host, "port:", port, "version:", param)
+		_this.listenTcp(host, port, param)
+	case "websocket":
+		_this.listenWebsocket(host, port, param)
+	case "kcp":
+		_this.listenKcp(host, port, param)
+	default:
+		logs.Panic(fmt.Sprintf("unknown network: %s", _this.network))
+	}
+}
 
-			addr, err := net.ResolveTCPAddr(param, fmt.Sprintf("%s:%d", host, port))
-			if err != nil {
-				logs.Panic("Resolve tcp addr err: ", err)
-				return
+// 获取 worker 池, 未初始化时使用默认配置
+func (_this *socket) getWorkers() *workerPool {
+	if _this.workers == nil {
+		_this.workers = newWorkerPool(1, 256, _this.handleRequest)
+	}
+
+	return _this.workers
+}
+
+// 启动 worker 池, 未初始化时使用默认配置, 只会启动一次
+func (_this *socket) startWorkers() {
+	_this.startOnce.Do(func() {
+		_this.checkPacker()
+		_this.checkReplay()
+		_this.handler = _this.chain()
+		_this.getWorkers().start()
+		_this.regMetrics()
+
+		for _, v := range _this.router.list() {
+			if v.Begin == v.End {
+				logs.System("route msgID:", v.Begin, "handler:", v.Handler)
+			} else {
+				logs.System(fmt.Sprintf("route msgID: [%d,%d] handler: %s", v.Begin, v.End, v.Handler))
 			}
+		}
+	})
+}
+
+// tcp 监听, 阻塞直到监听关闭
+func (_this *socket) listenTcp(host string, port int, param string) {
+	switch param {
+	case "":
+		param = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		logs.Panic(fmt.Sprintf("tcp socket unknown param: %s", param))
+		return
+	}
+
+	logs.System("tcp listen, host:", host, "port:", port, "version:", param)
+
+	addr, err := net.ResolveTCPAddr(param, fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		logs.Panic("Resolve tcp addr err: ", err)
+		return
+	}
+
+	var listener net.Listener
+	if listener, err = net.ListenTCP(param, addr); err != nil {
+		logs.Panic("listen", param, "err", err)
+		return
+	}
+
+	if config := _this.getTLSConfig(); config != nil {
+		logs.System("tcp listen tls enabled")
+		listener = tls.NewListener(listener, config)
+		_this.watchTLSReload()
+	}
+
+	_this.mu.Lock()
+	_this.listener = listener
+	_this.mu.Unlock()
 
-			listener, err := net.ListenTCP(param, addr)
-			if err != nil {
-				logs.Panic("listen", param, "err", err)
+	for {
+		tcpConn, err := listener.Accept()
+		if err != nil {
+			if _this.isClosing() {
+				logs.System("tcp listener closed, host:", host, "port:", port)
 				return
 			}
+			logs.Error("accept err ", err)
+			continue
+		}
 
-			for {
-				tcpConn, err := listener.AcceptTCP()
-				if err != nil {
-					logs.Error("accept err ", err)
-					continue
-				}
-
-				go func() {
-					conn := newConnTcp(1, _this, tcpConn)
-					conn.Start()
-				}()
-			}
-		default:
-			logs.Panic(fmt.Sprintf("tcp socket unknown param: %s", param))
+		go func() {
+			conn := newConnTcp(_this.newConnID(), _this, tcpConn)
+			conn.Start()
+		}()
+	}
+}
+
+// kcp 监听, 阻塞直到监听关闭
+func (_this *socket) listenKcp(host string, port int, param string) {
+	switch param {
+	case "":
+		param = "udp"
+	case "udp", "udp4", "udp6":
+	default:
+		logs.Panic(fmt.Sprintf("kcp socket unknown param: %s", param))
+		return
+	}
+
+	logs.System("kcp listen, host:", host, "port:", port, "version:", param)
+
+	addr, err := net.ResolveUDPAddr(param, fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		logs.Panic("Resolve udp addr err: ", err)
+		return
+	}
+
+	listener, err := listenKcp(param, addr)
+	if err != nil {
+		logs.Panic("listen", param, "err", err)
+		return
+	}
+
+	_this.mu.Lock()
+	_this.listener = listener
+	_this.mu.Unlock()
+
+	for {
+		sess, err := listener.Accept()
+		if err != nil {
+			logs.System("kcp listener closed, host:", host, "port:", port)
 			return
 		}
-	case "websocket":
-		logs.System("websocket listen, host:", host, "port:", port, "pattern:", param)
 
-		upGrader := websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+		go func() {
+			conn := newConnKcp(_this.newConnID(), _this, sess)
+			conn.Start()
+		}()
+	}
+}
+
+// websocket 监听, 不会阻塞
+func (_this *socket) listenWebsocket(host string, port int, pattern string) {
+	logs.System("websocket listen, host:", host, "port:", port, "pattern:", pattern)
+
+	upGrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, func(writer http.ResponseWriter, request *http.Request) {
+		if _this.isClosing() {
+			http.Error(writer, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// 先检查 ip, 被拒绝时不进行认证和升级
+		ip, ok := _this.admitUpgrade(writer, request)
+		if !ok {
+			return
+		}
+
+		// 升级前认证, 认证时设置的属性复制到连接的属性中
+		attr := &sync.Map{}
+		if !_this.authUpgrade(writer, request, attr) {
+			_this.ipFilter.release(ip)
+			return
+		}
+
+		var (
+			wsConn *websocket.Conn
+			err    error
+		)
+
+		if wsConn, err = upGrader.Upgrade(writer, request, nil); err != nil {
+			_this.ipFilter.release(ip)
+			return
 		}
 
 		go func() {
-			mux := http.NewServeMux()
-			mux.HandleFunc(param, func(writer http.ResponseWriter, request *http.Request) {
-				var (
-					wsConn *websocket.Conn
-					err    error
-				)
-
-				if wsConn, err = upGrader.Upgrade(writer, request, nil); err != nil {
-					return
-				}
-
-				go func() {
-					conn := newConnWebsocket(1, _this, wsConn)
-					conn.Start()
-				}()
+			conn := newConnWebsocket(_this.newConnID(), _this, wsConn)
+			conn.(*connCore).upgraded = true
+			attr.Range(func(key, value interface{}) bool {
+				conn.QueryAttr().Store(key, value)
+				return true
 			})
+			conn.Start()
+		}()
+	})
 
-			addr := host + ":" + strconv.Itoa(port)
-			if err := http.ListenAndServe(addr, mux); err != nil {
-				panic(err)
+	httpServer := &http.Server{Addr: host + ":" + strconv.Itoa(port), Handler: mux, TLSConfig: _this.getTLSConfig()}
+
+	_this.mu.Lock()
+	_this.httpServer = httpServer
+	_this.mu.Unlock()
+
+	if httpServer.TLSConfig != nil {
+		logs.System("websocket listen tls enabled (wss)")
+		_this.watchTLSReload()
+	}
+
+	go func() {
+		var err error
+		if httpServer.TLSConfig != nil {
+			// 证书由 TLSConfig 提供
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}()
+}
+
+func (_this *socket) GetConn(id int) (IConn, bool) {
+	return _this.conns.get(id)
+}
+
+func (_this *socket) Range(f func(IConn) bool) {
+	_this.conns.each(f)
+}
+
+func (_this *socket) Count() int {
+	return _this.conns.count()
+}
+
+func (_this *socket) Kick(id int) {
+	if conn, ok := _this.conns.get(id); ok {
+		stopConn(conn, ErrConnKicked)
+	}
+}
+
+func (_this *socket) BroadcastMsg(id int, data interface{}) {
+	_this.conns.each(func(conn IConn) bool {
+		conn.SendMsg(id, data)
+		return true
+	})
+}
+
+// 生成连接ID, 同一个 socket 下唯一
+func (_this *socket) newConnID() int {
+	return int(atomic.AddInt64(&_this.connID, 1))
+}
+
+func (_this *socket) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&_this.closing, 0, 1) {
+		return nil
+	}
+
+	// 握手或认证中的连接不在管理器中, 之后完成时由连接自行关闭
+	_this.conns.close()
+	logs.System("socket shutdown begin:", _this.network, "conns:", _this.conns.count())
+
+	// 关闭监听, 不再接受新连接
+	_this.mu.Lock()
+	if _this.listener != nil {
+		_this.listener.Close()
+	}
+	if _this.httpServer != nil {
+		_this.httpServer.Shutdown(ctx)
+	}
+	_this.stopTLSReload()
+	_this.mu.Unlock()
+
+	err := _this.drain(ctx)
+	if err != nil {
+		logs.Error("socket shutdown err:", err, "network:", _this.network)
+	}
+
+	// 停止所有连接, 触发断开连接回调, 保留中的会话同样结束
+	_this.conns.each(func(conn IConn) bool {
+		stopConn(conn, ErrServerShutdown)
+		return true
+	})
+	_this.endSessions()
+
+	logs.System("socket shutdown end:", _this.network)
+	return err
+}
+
+// 支持优雅关闭的连接
+type drainConn interface {
+	stopRead() <-chan bool
+	flush() <-chan bool
+}
+
+// 等待连接停止读取, 处理完 worker 池中的任务, 再写完所有连接缓存中的消息
+func (_this *socket) drain(ctx context.Context) error {
+	conns := make([]drainConn, 0, _this.conns.count())
+	_this.conns.each(func(conn IConn) bool {
+		if c, ok := conn.(drainConn); ok {
+			conns = append(conns, c)
+		}
+		return true
+	})
+
+	// 停止读取, reader 可能在检查关闭状态后又刷新了读超时, 因此需要重复设置
+	for _, conn := range conns {
+		conn.stopRead()
+	}
+	for _, conn := range conns {
+	wait:
+		for {
+			select {
+			case <-conn.stopRead():
+				break wait
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		}()
+		}
+	}
 
-	default:
-		logs.Panic(fmt.Sprintf("unknown network: %s", _this.network))
+	// 处理完 worker 池中的任务
+	if _this.workers != nil {
+		if err := _this.workers.stop(ctx); err != nil {
+			return err
+		}
+	}
+
+	// 写完所有连接缓存中的消息
+	for _, conn := range conns {
+		select {
+		case <-conn.flush():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// socket 是否正在关闭
+func (_this *socket) isClosing() bool {
+	return atomic.LoadInt32(&_this.closing) == 1
+}
+
+// 以指定原因停止连接
+func stopConn(conn IConn, reason error) {
+	if c, ok := conn.(interface{ stop(error) }); ok {
+		c.stop(reason)
+	} else {
+		conn.Stop()
 	}
 }
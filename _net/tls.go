@@ -0,0 +1,138 @@
+package _net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/fly-way/gofly/logs"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func (_this *socket) SetTLS(certFile string, keyFile string) {
+	_this.certFile = certFile
+	_this.keyFile = keyFile
+
+	if err := _this.ReloadTLS(); err != nil {
+		logs.Panic("socket load tls cert err:", err)
+	}
+}
+
+func (_this *socket) SetTLSConfig(config *tls.Config) {
+	_this.tlsConfig = config
+}
+
+func (_this *socket) SetTLSClientCA(caFile string) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		logs.Panic("socket read client ca err:", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		logs.Panic("socket parse client ca err, file:", caFile)
+	}
+
+	_this.clientCAs = pool
+}
+
+func (_this *socket) ReloadTLS() error {
+	if _this.certFile == "" {
+		return errors.New("tls cert file not set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(_this.certFile, _this.keyFile)
+	if err != nil {
+		return err
+	}
+
+	_this.cert.Store(&cert)
+	logs.System("socket load tls cert:", _this.certFile)
+	return nil
+}
+
+// 获取 tls 配置, 未开启 tls 时返回 nil
+func (_this *socket) getTLSConfig() *tls.Config {
+	if _this.client {
+		return _this.clientTLSConfig()
+	}
+
+	if _this.tlsConfig == nil && _this.certFile == "" {
+		return nil
+	}
+
+	config := &tls.Config{}
+	if _this.tlsConfig != nil {
+		config = _this.tlsConfig.Clone()
+	}
+
+	// 每次握手时读取最新的证书, 重新加载证书不影响已建立的连接
+	if _this.certFile != "" {
+		config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return _this.cert.Load().(*tls.Certificate), nil
+		}
+	}
+
+	if _this.clientCAs != nil {
+		config.ClientCAs = _this.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config
+}
+
+// 客户端的 tls 配置, SetTLS 的证书作为客户端证书, SetTLSClientCA 的 ca 用于校验服务端证书
+func (_this *socket) clientTLSConfig() *tls.Config {
+	if _this.tlsConfig == nil && _this.certFile == "" && _this.clientCAs == nil {
+		return nil
+	}
+
+	config := &tls.Config{}
+	if _this.tlsConfig != nil {
+		config = _this.tlsConfig.Clone()
+	}
+
+	if _this.certFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return _this.cert.Load().(*tls.Certificate), nil
+		}
+	}
+
+	if _this.clientCAs != nil {
+		config.RootCAs = _this.clientCAs
+	}
+
+	return config
+}
+
+// 收到 SIGHUP 信号时重新加载证书
+func (_this *socket) watchTLSReload() {
+	if _this.certFile == "" {
+		return
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	_this.mu.Lock()
+	_this.tlsReload = c
+	_this.mu.Unlock()
+
+	go func() {
+		for range c {
+			if err := _this.ReloadTLS(); err != nil {
+				logs.Error("socket reload tls cert err:", err)
+			}
+		}
+	}()
+}
+
+// 停止监听重新加载证书的信号
+func (_this *socket) stopTLSReload() {
+	if _this.tlsReload != nil {
+		signal.Stop(_this.tlsReload)
+		close(_this.tlsReload)
+	}
+}
@@ -1,47 +1,207 @@
 package _net
 
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
 // worker 本质就是处理请求的 channel
-type worker chan Request
+type worker struct {
+	tasks chan Request
+	// 缩小 worker 池时关闭
+	quit chan bool
+	// 已处理的任务数量
+	handled int64
+}
 
 type workerPool struct {
+	// 修改 worker 池时加写锁, 读取 worker 池时加读锁
+	mu sync.RWMutex
+	// 同一时间只有一个调整大小的操作
+	resizeMu sync.Mutex
+	// 调整大小期间不为 nil, 添加任务时等待关闭后再添加
+	paused chan bool
 	// worker 池
-	workerPool []worker
+	workerPool []*worker
 	// 每个 worker 最大缓存任务数量
 	taskSize int
 	// 任务处理回调
 	request func(Request)
 	// worker 池长度
 	size int
+	// 是否已启动
+	started bool
+	// 已添加但未处理完成的任务
+	pending sync.WaitGroup
+	// 关闭信号
+	exit chan bool
 }
 
 // 初始化 worker 池
 func newWorkerPool(poolSize int, taskSize int, request func(Request)) *workerPool {
 	return &workerPool{
-		workerPool: make([]worker, poolSize),
-		taskSize:   taskSize,
-		request:    request,
-		size:       poolSize,
+		taskSize: taskSize,
+		request:  request,
+		size:     poolSize,
+		exit:     make(chan bool),
 	}
 }
 
 // 启动 worker 池
 func (_this *workerPool) start() {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.workerPool = make([]*worker, _this.size)
 	for i := 0; i < _this.size; i++ {
-		_this.workerPool[i] = make(chan Request, _this.taskSize)
-
-		go func(idx int) {
-			for {
-				select {
-				case request := <-_this.workerPool[idx]:
-					_this.request(request)
-				}
+		_this.workerPool[i] = _this.newWorker()
+	}
+	_this.started = true
+}
+
+// 创建并启动一个 worker
+func (_this *workerPool) newWorker() *worker {
+	w := &worker{
+		tasks: make(chan Request, _this.taskSize),
+		quit:  make(chan bool),
+	}
+
+	go func() {
+		for {
+			select {
+			case request := <-w.tasks:
+				_this.request(request)
+				atomic.AddInt64(&w.handled, 1)
+				_this.pending.Done()
+			case <-w.quit:
+				return
+			case <-_this.exit:
+				return
 			}
-		}(i)
+		}
+	}()
+
+	return w
+}
+
+// 立即关闭 worker 池, 不等待缓存中的任务, 返回相同配置的未启动的 worker 池
+func (_this *workerPool) reset() *workerPool {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	select {
+	case <-_this.exit:
+	default:
+		close(_this.exit)
 	}
+
+	return newWorkerPool(_this.size, _this.taskSize, _this.request)
 }
 
-// 添加任务
+// 添加任务, worker 缓存已满或调整大小期间阻塞调用方
+// 按 Request.Key 绑定 worker, 在连接的 reader 中依次添加, 同一个 key 的消息处理是有序的
 func (_this *workerPool) addTask(task Request) {
-	// 每一条连接绑定一个 worker, 单条连接的消息处理是有序的
-	_this.workerPool[task.Conn.GetConnID()%_this.size] <- task
+	for {
+		_this.mu.RLock()
+		if paused := _this.paused; paused != nil {
+			_this.mu.RUnlock()
+
+			select {
+			case <-paused:
+				continue
+			case <-_this.exit:
+				return
+			}
+		}
+
+		_this.pending.Add(1)
+		w := _this.workerPool[uint(task.Key)%uint(len(_this.workerPool))]
+		_this.mu.RUnlock()
+
+		select {
+		case w.tasks <- task:
+		case <-_this.exit:
+			_this.pending.Done()
+		}
+		return
+	}
+}
+
+// 调整 worker 池大小
+// 先暂停添加任务, 等待已添加的任务全部处理完成后再调整, 保证同一个 key 的消息处理依然有序
+// 等待期间不持有锁, 处理函数中可以调用 stats
+func (_this *workerPool) resize(size int) {
+	if size <= 0 {
+		return
+	}
+
+	_this.resizeMu.Lock()
+	defer _this.resizeMu.Unlock()
+
+	_this.mu.Lock()
+	// 未启动时只修改大小
+	if !_this.started {
+		_this.size = size
+		_this.mu.Unlock()
+		return
+	}
+
+	paused := make(chan bool)
+	_this.paused = paused
+	_this.mu.Unlock()
+
+	_this.pending.Wait()
+
+	_this.mu.Lock()
+	if size < len(_this.workerPool) {
+		for _, w := range _this.workerPool[size:] {
+			close(w.quit)
+		}
+		_this.workerPool = _this.workerPool[:size]
+	}
+
+	for len(_this.workerPool) < size {
+		_this.workerPool = append(_this.workerPool, _this.newWorker())
+	}
+	_this.size = size
+	_this.paused = nil
+	_this.mu.Unlock()
+
+	close(paused)
+}
+
+// 每个 worker 的状态
+func (_this *workerPool) stats() []WorkerStat {
+	_this.mu.RLock()
+	defer _this.mu.RUnlock()
+
+	stats := make([]WorkerStat, 0, len(_this.workerPool))
+	for _, w := range _this.workerPool {
+		stats = append(stats, WorkerStat{
+			Queue:   len(w.tasks),
+			Cap:     cap(w.tasks),
+			Handled: atomic.LoadInt64(&w.handled),
+		})
+	}
+
+	return stats
+}
+
+// 等待已添加的任务全部处理完成后关闭 worker 池
+// ctx 超时返回 ctx.Err(), 此时 worker 池不会关闭
+func (_this *workerPool) stop(ctx context.Context) error {
+	done := make(chan bool)
+	go func() {
+		_this.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		close(_this.exit)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
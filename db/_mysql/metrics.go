@@ -0,0 +1,15 @@
+package _mysql
+
+import "github.com/fly-way/gofly/metrics"
+
+// mysql 指标, db 标签为数据库名称
+var (
+	mysqlQueue     = metrics.NewGauge("gofly_mysql_queue", "Tasks waiting in the mysql work queue.", "db")
+	mysqlQueryTime = metrics.NewHistogram("gofly_mysql_query_seconds", "Mysql query latency.", nil, "db", "ope")
+)
+
+var opeName = map[int]string{
+	opeQuery:     "query",
+	opeQueryMore: "query_more",
+	opeExec:      "exec",
+}
@@ -3,8 +3,10 @@ package _mysql
 import (
 	"fmt"
 	"github.com/fly-way/gofly/logs"
+	"github.com/fly-way/gofly/metrics"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"time"
 )
 
 const (
@@ -27,6 +29,7 @@ type mysqlConn struct {
 	closeChan chan bool
 	idleConn  int
 	openConn  int
+	dbName    string
 }
 
 func (_this *mysqlConn) SetConnPoolInfo(idleConn, openConn int) {
@@ -59,6 +62,11 @@ func (_this *mysqlConn) StartConn(ip string, port int, user string, pwd string,
 		_this.obj.SetMaxOpenConns(_this.openConn)
 	}
 
+	_this.dbName = dbName
+	metrics.RegCollect(func() {
+		mysqlQueue.Set(float64(len(_this.works)), dbName)
+	})
+
 	go _this.startWork()
 
 	logs.System("Mysql StartConn:", dsn, "idleConn=", _this.idleConn, "openConn=", _this.openConn, "workSize=", workSize)
@@ -114,6 +122,7 @@ func (_this *mysqlConn) startWork() {
 
 		if ok {
 			var err error
+			begin := time.Now()
 			switch result.ope {
 			case opeQuery:
 				err = _this.obj.Get(result.data, result.query)
@@ -124,6 +133,7 @@ func (_this *mysqlConn) startWork() {
 			case opeExec:
 				_, err = _this.obj.Exec(result.query, result.args...)
 			}
+			mysqlQueryTime.Observe(time.Since(begin).Seconds(), _this.dbName, opeName[result.ope])
 
 			if err != nil {
 				logs.Error(fmt.Sprintf("Mysql ope failed, error:[%v], query:[%s]", err.Error(), result.query))
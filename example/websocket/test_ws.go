@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"github.com/fly-way/gofly/_net"
 	"github.com/fly-way/gofly/logs"
-	"github.com/gorilla/websocket"
 	"time"
 )
 
@@ -30,44 +27,21 @@ func server() {
 	srv.Start()
 }
 
-func pack(id uint32, data interface{}) []byte {
-	buff := bytes.NewBuffer([]byte{})
-	if binary.Write(buff, binary.BigEndian, id) != nil {
-		return nil
-	}
-
-	if binary.Write(buff, binary.BigEndian, data) != nil {
-		return nil
-	}
-
-	return buff.Bytes()
-}
-
 func client() {
-	url := "ws://127.0.0.1:9999/ws" //服务器地址
-	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	cli := _net.NewClientSocket("websocket")
+	cli.Route(1000, func(request _net.Request) {
+		logs.Debug("client receive [id,data]:", request.ID, string(request.Data.([]byte)))
+	})
+
+	conn, err := _net.DialWebsocket("ws://127.0.0.1:9999/ws", cli) //服务器地址
 	if err != nil {
 		logs.Panic(err)
 	}
-
-	go func() {
-		for {
-			msg := pack(1, []byte("ping"))
-			err := ws.WriteMessage(websocket.BinaryMessage, msg)
-			if err != nil {
-				logs.Panic(err)
-			}
-			time.Sleep(time.Second * 2)
-		}
-	}()
+	conn.SetReconnect(time.Second, 10*time.Second)
 
 	for {
-		_, data, err := ws.ReadMessage()
-		if err != nil {
-			logs.Panic(err)
-		}
-
-		logs.Debug("client receive [id,data]:", binary.BigEndian.Uint32(data[:4]), string(data[4:]))
+		conn.SendMsg(1, []byte("ping"))
+		time.Sleep(time.Second * 2)
 	}
 }
 
@@ -8,6 +8,9 @@ var (
 	// 是否输出debug日志
 	SetDebug  func(bool)
 
+	// 当前是否输出debug日志
+	IsDebug   func() bool
+
 	// 日志输出
 	Debug     func(...interface{})
 	Logic     func(...interface{})
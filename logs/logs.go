@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -26,12 +27,12 @@ var logName = []string{
 
 var (
 	logs  []*logger
-	debug bool
+	debug int32
 )
 
 func init() {
 	logs = make([]*logger, len(logName))
-	debug = true
+	debug = 1
 	for k, v := range logName {
 		logs[k] = new(lStdFlags, "[" + strings.ToUpper(v) + "]", 2)
 	}
@@ -42,8 +43,20 @@ func init() {
 		}
 	}
 
+	SetDebug = func(b bool) {
+		if b {
+			atomic.StoreInt32(&debug, 1)
+		} else {
+			atomic.StoreInt32(&debug, 0)
+		}
+	}
+
+	IsDebug = func() bool {
+		return atomic.LoadInt32(&debug) == 1
+	}
+
 	Debug = func(v ...interface{}) {
-		if debug {
+		if IsDebug() {
 			logs[logDebug].output(fmt.Sprintln(v...))
 		}
 	}
@@ -0,0 +1,61 @@
+package metrics
+
+import "net/http"
+
+// 创建计数器, 只增不减, 如收到的消息数量
+// name 指标名称, help 指标说明, labels 标签名称, 如 NewCounter("net_msg_total", "...", "socket", "msg_id")
+// 同名指标只能注册一次, 重复注册会 panic
+func NewCounter(name string, help string, labels ...string) ICounter {
+	return defaultRegistry.register(newMetric(name, help, typeCounter, nil, labels))
+}
+
+// 创建仪表, 可增可减, 如在线连接数
+func NewGauge(name string, help string, labels ...string) IGauge {
+	return defaultRegistry.register(newMetric(name, help, typeGauge, nil, labels))
+}
+
+// 创建直方图, 如处理耗时
+// buckets 为各个桶的上限, 升序排列, 为 nil 时使用 DefBuckets
+func NewHistogram(name string, help string, buckets []float64, labels ...string) IHistogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	return defaultRegistry.register(newMetric(name, help, typeHistogram, buckets, labels))
+}
+
+// 注册采集回调, 每次输出指标前调用
+// 用于队列长度这类只需要在采集时读取的指标, 在回调中调用 IGauge.Set 更新
+// 返回取消注册的函数, 回调引用的对象不再使用时调用
+func RegCollect(f func()) func() {
+	return defaultRegistry.regCollect(f)
+}
+
+// 以 Prometheus 文本格式输出所有指标的 http 处理函数
+func Handler() http.Handler {
+	return http.HandlerFunc(defaultRegistry.serveHTTP)
+}
+
+// 默认的直方图桶, 单位为秒, 适用于请求耗时
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type ICounter interface {
+	// 加 1, values 为标签值, 顺序与创建时的标签名称一致
+	Inc(values ...string)
+	// 加 v, v 不能为负数
+	Add(v float64, values ...string)
+}
+
+type IGauge interface {
+	// 设置为 v
+	Set(v float64, values ...string)
+	// 加 v, v 可以为负数
+	Add(v float64, values ...string)
+	// 删除标签值对应的数据, 用于清理已经不存在的对象, 如已关闭的 worker
+	Delete(values ...string)
+}
+
+type IHistogram interface {
+	// 记录一次观测值
+	Observe(v float64, values ...string)
+}
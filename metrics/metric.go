@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fly-way/gofly/logs"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+var defaultRegistry = &registry{metrics: make(map[string]*metric)}
+
+// 指标注册表
+type registry struct {
+	mu       sync.Mutex
+	metrics  map[string]*metric
+	collects []*collect
+}
+
+// 采集回调, 按指针区分, 用于取消注册
+type collect struct {
+	f func()
+}
+
+func (_this *registry) register(m *metric) *metric {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	if _, ok := _this.metrics[m.name]; ok {
+		logs.Panic("metrics register duplicate name:", m.name)
+	}
+	_this.metrics[m.name] = m
+
+	return m
+}
+
+func (_this *registry) regCollect(f func()) func() {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	c := &collect{f: f}
+	_this.collects = append(_this.collects, c)
+
+	return func() {
+		_this.mu.Lock()
+		defer _this.mu.Unlock()
+
+		for i, v := range _this.collects {
+			if v == c {
+				_this.collects = append(_this.collects[:i], _this.collects[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// 按名称顺序输出所有指标
+func (_this *registry) write(w io.Writer) {
+	_this.mu.Lock()
+	collects := append([]*collect{}, _this.collects...)
+	metrics := make([]*metric, 0, len(_this.metrics))
+	for _, m := range _this.metrics {
+		metrics = append(metrics, m)
+	}
+	_this.mu.Unlock()
+
+	for _, c := range collects {
+		c.f()
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (_this *registry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	buff := &bytes.Buffer{}
+	_this.write(buff)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buff.Bytes())
+}
+
+// 一个指标, 按标签值保存数据
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	buckets []float64
+	labels  []string
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// 一组标签值对应的数据
+type series struct {
+	values []string
+	value  float64
+	// 直方图使用, counts[i] 为不大于 buckets[i] 的观测次数
+	counts []uint64
+	count  uint64
+}
+
+func newMetric(name, help, typ string, buckets []float64, labels []string) *metric {
+	return &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		buckets: buckets,
+		labels:  labels,
+		series:  make(map[string]*series),
+	}
+}
+
+func (_this *metric) Inc(values ...string) {
+	_this.Add(1, values...)
+}
+
+func (_this *metric) Add(v float64, values ...string) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.get(values).value += v
+}
+
+func (_this *metric) Set(v float64, values ...string) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	_this.get(values).value = v
+}
+
+func (_this *metric) Delete(values ...string) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	delete(_this.series, strings.Join(values, "\xff"))
+}
+
+func (_this *metric) Observe(v float64, values ...string) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	s := _this.get(values)
+	for i, bound := range _this.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// 获取标签值对应的数据, 不存在时创建, 调用前需要加锁
+func (_this *metric) get(values []string) *series {
+	if len(values) != len(_this.labels) {
+		logs.Panic(fmt.Sprintf("metrics %s label values count: %d, need: %d", _this.name, len(values), len(_this.labels)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := _this.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		if _this.typ == typeHistogram {
+			s.counts = make([]uint64, len(_this.buckets))
+		}
+		_this.series[key] = s
+	}
+
+	return s
+}
+
+// 按 Prometheus 文本格式输出
+func (_this *metric) write(w io.Writer) {
+	_this.mu.Lock()
+	defer _this.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", _this.name, escapeHelp(_this.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", _this.name, _this.typ)
+
+	keys := make([]string, 0, len(_this.series))
+	for k := range _this.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := _this.series[k]
+		if _this.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", _this.name, _this.labelText(s.values, "", 0), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range _this.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", _this.name, _this.labelText(s.values, "le", bound), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", _this.name, _this.labelText(s.values, "le", math.Inf(1)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", _this.name, _this.labelText(s.values, "", 0), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", _this.name, _this.labelText(s.values, "", 0), s.count)
+	}
+}
+
+// 标签文本, 如 {socket="tcp",msg_id="1"}, le 不为空时追加直方图的桶上限
+func (_this *metric) labelText(values []string, le string, bound float64) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", _this.labels[i], escapeLabel(v)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", le, formatFloat(bound)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -126,3 +126,44 @@ func AesDecrypt(encryptData, key []byte, iv []byte) ([]byte, error) {
 
 	return origData, nil
 }
+
+// GCM 模式加密, 每次加密使用随机 nonce, 返回 nonce + 密文 + 认证标签
+// key 的 16 位, 24, 32 分别对应 AES-128, AES-192, AES-256
+// additionalData 只参与认证不加密, 解密时需要传入相同的值, 可为 nil
+func GcmSeal(rawData, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(rawData)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, rawData, additionalData), nil
+}
+
+// GCM 模式解密, 密文或 additionalData 被篡改时返回错误
+func GcmOpen(encryptData, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encryptData) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("cipher text too short")
+	}
+
+	nonce := encryptData[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, encryptData[gcm.NonceSize():], additionalData)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}